@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/fanatic/git-training/audit"
+	"github.com/fanatic/git-training/content"
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/dryrun"
+	"github.com/fanatic/git-training/handlers"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/fanatic/git-training/store"
+	"github.com/fanatic/git-training/webhook"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// app is the bot wired together: the engine, the outbox and webhook queue
+// behind it, and the handler that serves webhooks and the admin endpoints.
+type app struct {
+	engine   *handlers.Engine
+	outbox   *outbox.Outbox
+	queue    *webhook.Queue
+	recorder *webhook.Recorder
+	handler  http.Handler
+}
+
+// newApp wires the bot to GitHub through cc. In dry-run mode, journal is
+// where cc's middleware records the writes it holds back.
+func newApp(cfg Config, cc githubapp.ClientCreator, st store.Store, journal *dryrun.Journal) (*app, error) {
+	text, err := content.New(cfg.ContentDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load content")
+	}
+	if err := handlers.CheckMessages(text); err != nil {
+		return nil, errors.Wrap(err, "invalid content")
+	}
+	c, err := course.Load(cfg.Course, text)
+	if err != nil {
+		return nil, err
+	}
+	setGitHubURLs(&cfg.Github)
+	links := cfg.Links
+	links.WebURL = cfg.Github.WebURL
+	if links, err = c.Links(links); err != nil {
+		return nil, errors.Wrap(err, "invalid links")
+	}
+	ob, err := outbox.New(cc, st, cfg.Outbox)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load outbox")
+	}
+	source, err := progress.Open(cfg.Progress, st, queuedPosts(ob))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load progress")
+	}
+	dedup, err := webhook.NewDeduplicator(st, cfg.Webhooks.DedupTTL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load deliveries")
+	}
+	engine := &handlers.Engine{ClientCreator: cc, Course: c, Progress: source, Audit: audit.New(st), Outbox: ob, Content: text, Links: links}
+
+	queue := webhook.NewQueue(cfg.Webhooks.Workers, cfg.Webhooks.QueueSize)
+	var webhookHandler http.Handler = githubapp.NewDefaultEventDispatcher(
+		cfg.Github,
+		queue.Wrap(dedup.Wrap(&handlers.IssuesHandler{Engine: engine})),
+		queue.Wrap(dedup.Wrap(&handlers.CreateHandler{Engine: engine})),
+		queue.Wrap(dedup.Wrap(&handlers.PushHandler{Engine: engine})),
+		queue.Wrap(dedup.Wrap(&handlers.PullRequestHandler{Engine: engine})),
+		queue.Wrap(dedup.Wrap(&handlers.IssueCommentHandler{Engine: engine})),
+	)
+
+	var recorder *webhook.Recorder
+	if cfg.Webhooks.RecordDir != "" {
+		recorder = webhook.NewRecorder(cfg.Webhooks.RecordDir, cfg.Github.App.WebhookSecret, recordFiles(cc))
+		webhookHandler = recorder.Wrap(webhookHandler)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", webhookHandler)
+	mux.Handle("/admin/", &handlers.AdminHandler{Engine: engine, Token: cfg.AdminToken, Journal: journal})
+
+	return &app{engine: engine, outbox: ob, queue: queue, recorder: recorder, handler: mux}, nil
+}
+
+// settle waits for the queued webhooks to be handled and recorded and then
+// makes one attempt at delivering the outbox.
+func (a *app) settle(ctx context.Context) error {
+	a.queue.Wait()
+	if a.recorder != nil {
+		a.recorder.Wait()
+	}
+	return a.outbox.Flush(ctx)
+}
+
+// queuedPosts lists the comments and reviews waiting in the outbox, so that
+// progress read from markers takes in those the bot hasn't posted yet.
+func queuedPosts(ob *outbox.Outbox) func() ([]progress.Queued, error) {
+	return func() ([]progress.Queued, error) {
+		messages, err := ob.Pending()
+		if err != nil {
+			return nil, err
+		}
+		var queued []progress.Queued
+		for _, m := range messages {
+			body := m.Body
+			if m.Review != nil {
+				body = m.Review.GetBody()
+			}
+			queued = append(queued, progress.Queued{
+				Key:  progress.Key{InstallationID: m.InstallationID, Owner: m.Owner, Repo: m.Repo},
+				Body: body,
+				At:   m.CreatedAt,
+			})
+		}
+		return queued, nil
+	}
+}
+
+// setGitHubURLs fills in the GitHub URLs that aren't configured. Without an
+// API URL the bot talks to github.com; with one on another host, as on GitHub
+// Enterprise Server, the GraphQL API and the web interface are on that host.
+func setGitHubURLs(c *githubapp.Config) {
+	if c.V3APIURL == "" {
+		c.V3APIURL = "https://api.github.com/"
+	}
+	u, err := url.Parse(c.V3APIURL)
+	if err != nil {
+		return
+	}
+	if u.Host == "api.github.com" {
+		if c.V4APIURL == "" {
+			c.V4APIURL = "https://api.github.com/graphql"
+		}
+		if c.WebURL == "" {
+			c.WebURL = "https://github.com"
+		}
+		return
+	}
+	if c.V4APIURL == "" {
+		c.V4APIURL = u.Scheme + "://" + u.Host + "/api/graphql"
+	}
+	if c.WebURL == "" {
+		c.WebURL = u.Scheme + "://" + u.Host
+	}
+}
+
+// checkApp makes sure the app can authenticate to the GitHub it's configured
+// for, so that a wrong key or host shows up at startup rather than on the
+// first webhook.
+func checkApp(ctx context.Context, cc githubapp.ClientCreator, c githubapp.Config) error {
+	client, err := cc.NewAppClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create app client")
+	}
+	app, _, err := client.Apps.Get(ctx, "")
+	if err != nil {
+		return errors.Wrapf(err, "failed to authenticate as app %d at %s", c.App.IntegrationID, c.V3APIURL)
+	}
+	logrus.Infof("Authenticated as app %s at %s", app.GetName(), c.V3APIURL)
+	return nil
+}
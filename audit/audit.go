@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/store"
+	"github.com/pkg/errors"
+)
+
+const bucket = "audit"
+
+// Entry records one use, or attempted use, of a privileged command.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Repo    string    `json:"repo"`
+	Issue   int       `json:"issue"`
+	Actor   string    `json:"actor"`
+	Command string    `json:"command"`
+	Target  string    `json:"target,omitempty"`
+	Args    []string  `json:"args,omitempty"`
+	Allowed bool      `json:"allowed"`
+	Result  string    `json:"result"`
+}
+
+// Log is an append-only audit log kept in a store.
+type Log struct {
+	store store.Store
+	seq   uint64
+}
+
+func New(s store.Store) *Log {
+	return &Log{store: s}
+}
+
+// Record appends the entry to the log and writes it to the application log.
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	logrus.WithFields(logrus.Fields{
+		"repo":    e.Repo,
+		"issue":   e.Issue,
+		"actor":   e.Actor,
+		"command": e.Command,
+		"target":  e.Target,
+		"allowed": e.Allowed,
+	}).Infof("Audit: %s", e.Result)
+
+	// Keys sort in the order entries were recorded.
+	key := fmt.Sprintf("%s/%06d", e.Time.Format("20060102T150405.000000000"), atomic.AddUint64(&l.seq, 1)%1000000)
+	return errors.Wrap(store.PutJSON(l.store, bucket, key, e), "failed to record audit entry")
+}
+
+// List returns every entry, oldest first.
+func (l *Log) List() ([]Entry, error) {
+	var entries []Entry
+	err := l.store.ForEach(bucket, func(key string, value []byte) error {
+		var e Entry
+		if err := json.Unmarshal(value, &e); err != nil {
+			return errors.Wrapf(err, "failed to decode audit entry %s", key)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
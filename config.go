@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/ctrlaltdel121/configor"
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/fanatic/git-training/store"
+	"github.com/fanatic/git-training/webhook"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is everything the bot can be configured with. Each setting can be
+// given in config.yml or in the environment variable named after its path,
+// such as STORAGE_PATH for storage.path or GITHUB_APP_WEBHOOK_SECRET for
+// github.app.webhook_secret; the environment wins.
+type Config struct {
+	Github             githubapp.Config `yaml:"github"`
+	Port               int              `yaml:"port" default:"8080"`
+	Course             string           `yaml:"course" default:"courses/intro.yml"`
+	ContentDir         string           `yaml:"content_dir"`
+	Links              course.Links     `yaml:"links"`
+	Storage            store.Config     `yaml:"storage"`
+	Progress           progress.Config  `yaml:"progress"`
+	Outbox             outbox.Config    `yaml:"outbox"`
+	Webhooks           webhook.Config   `yaml:"webhooks"`
+	ReconcileOnStartup bool             `yaml:"reconcile_on_startup"`
+	DryRun             bool             `yaml:"dry_run"`
+	AdminToken         string           `yaml:"admin_token"`
+}
+
+// envAliases are the older names of some environment variables, which still
+// work.
+var envAliases = map[string]string{
+	"INTEGRATION_ID":       "GITHUB_APP_INTEGRATION_ID",
+	"WEBHOOK_SECRET":       "GITHUB_APP_WEBHOOK_SECRET",
+	"GITHUB_PRIVATE_KEY":   "GITHUB_APP_PRIVATE_KEY",
+	"GITHUB_CLIENT_ID":     "GITHUB_OAUTH_CLIENT_ID",
+	"GITHUB_CLIENT_SECRET": "GITHUB_OAUTH_CLIENT_SECRET",
+}
+
+// loadConfig reads the config from the files that exist and then from the
+// environment, fills in the defaults, and reads the app's private key from a
+// file if it's given as a path. It returns the settings it couldn't use,
+// such as a variable that doesn't parse, alongside the config; the error is
+// for a file that can't be read.
+func loadConfig(getenv func(string) string, files ...string) (Config, []string, error) {
+	var cfg Config
+	if err := configor.Load(&cfg, files...); err != nil {
+		return cfg, nil, errors.Wrap(err, "failed to load config")
+	}
+
+	lookup := func(name string) string {
+		if value := getenv(name); value != "" {
+			return value
+		}
+		for alias, canonical := range envAliases {
+			if canonical == name {
+				if value := getenv(alias); value != "" {
+					return value
+				}
+			}
+		}
+		return ""
+	}
+	problems := setFromEnv(reflect.ValueOf(&cfg).Elem(), "", lookup)
+
+	if err := cfg.readPrivateKey(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	setGitHubURLs(&cfg.Github)
+	return cfg, problems, nil
+}
+
+// setFromEnv sets each setting in v whose environment variable is set, and
+// returns the ones that don't parse.
+func setFromEnv(v reflect.Value, prefix string, getenv func(string) string) []string {
+	var problems []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		env := prefix + strings.ToUpper(name)
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			problems = append(problems, setFromEnv(field, env+"_", getenv)...)
+			continue
+		}
+		value := getenv(env)
+		if value == "" {
+			continue
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+			continue
+		}
+		if err := yaml.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q isn't a valid %s", env, value, field.Type()))
+		}
+	}
+	return problems
+}
+
+// readPrivateKey replaces a private key given as a path with the contents of
+// the file.
+func (c *Config) readPrivateKey() error {
+	key := c.Github.App.PrivateKey
+	if key == "" || strings.Contains(key, "-----BEGIN") {
+		// Keys in environment variables often have their line breaks escaped.
+		if !strings.Contains(key, "\n") {
+			c.Github.App.PrivateKey = strings.Replace(key, `\n`, "\n", -1)
+		}
+		return nil
+	}
+	data, err := ioutil.ReadFile(key)
+	if err != nil {
+		return errors.Wrap(err, "github.app.private_key isn't a PEM key or a file that can be read")
+	}
+	c.Github.App.PrivateKey = string(data)
+	return nil
+}
+
+// problems lists what's wrong with the config, so that it can all be fixed
+// at once.
+func (c Config) problems() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Github.App.IntegrationID <= 0 {
+		add("github.app.integration_id must be the app's ID")
+	}
+	if c.Github.App.WebhookSecret == "" {
+		add("github.app.webhook_secret is empty, so webhooks can't be verified")
+	}
+	if c.Github.App.PrivateKey == "" {
+		add("github.app.private_key is empty")
+	} else if err := checkPrivateKey(c.Github.App.PrivateKey); err != nil {
+		add("github.app.private_key: %s", err)
+	}
+
+	urls := []struct{ name, value string }{
+		{"github.v3_api_url", c.Github.V3APIURL},
+		{"github.v4_api_url", c.Github.V4APIURL},
+		{"github.web_url", c.Github.WebURL},
+		{"links.docs_url", c.Links.DocsURL},
+	}
+	for name, value := range c.Links.Videos {
+		urls = append(urls, struct{ name, value string }{"links.videos." + name, value})
+	}
+	for _, u := range urls {
+		if err := checkURL(u.value); err != nil {
+			add("%s: %s", u.name, err)
+		}
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		add("port %d isn't a TCP port", c.Port)
+	}
+	switch c.Storage.Driver {
+	case store.DriverMemory:
+	case store.DriverFile, store.DriverBolt:
+		if c.Storage.Path == "" {
+			add("storage.path is required by the %s driver", c.Storage.Driver)
+		}
+	default:
+		add("storage.driver %q isn't one of memory, file or bolt", c.Storage.Driver)
+	}
+	switch c.Progress.Source {
+	case progress.SourceStore, progress.SourceMarkers:
+	default:
+		add("progress.source %q isn't one of store or markers", c.Progress.Source)
+	}
+	if c.Webhooks.Workers <= 0 {
+		add("webhooks.workers must be at least 1")
+	}
+	return problems
+}
+
+// checkPrivateKey makes sure the key is an RSA private key GitHub could have
+// issued.
+func checkPrivateKey(key string) error {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return errors.New("not a PEM-encoded key")
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		return errors.Wrap(err, "not an RSA private key")
+	}
+	return nil
+}
+
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("%q isn't an http or https URL", value)
+	}
+	return nil
+}
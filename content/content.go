@@ -0,0 +1,213 @@
+// Package content holds the text the bot posts: the message templates courses
+// name and the message catalogs for everything else, in each language it
+// speaks. It is embedded in the binary so that it runs without the source
+// tree.
+package content
+
+import (
+	"embed"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fanatic/git-training/course"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed templates catalogs
+var embedded embed.FS
+
+// Content reads templates and catalogs from the override directory when it
+// has them, and from the embedded copies otherwise. Both are laid out the
+// same way:
+//
+//	templates/<locale>/<name>   message templates, such as templates/en/intro/welcome.md
+//	catalogs/<locale>.yml       messages, and translations of course titles and hints
+type Content struct {
+	dir      string
+	locales  []string
+	catalogs map[string]catalog
+}
+
+// catalog is one language's messages, keyed by name, and its translations of
+// courses, keyed by course id.
+type catalog struct {
+	Messages map[string]string             `yaml:"messages"`
+	Courses  map[string]course.Translation `yaml:"courses"`
+}
+
+// New loads the content, overridden by the files in dir, if it isn't empty.
+// Messages in an override catalog replace the embedded ones one by one.
+func New(dir string) (*Content, error) {
+	c := &Content{dir: dir, catalogs: map[string]catalog{}}
+
+	seen := map[string]bool{course.DefaultLocale: true}
+	for _, fsys := range c.layers() {
+		entries, _ := fs.ReadDir(fsys, "templates")
+		for _, e := range entries {
+			if e.IsDir() {
+				seen[e.Name()] = true
+			}
+		}
+		entries, _ = fs.ReadDir(fsys, "catalogs")
+		for _, e := range entries {
+			if locale := strings.TrimSuffix(e.Name(), ".yml"); !e.IsDir() && locale != e.Name() {
+				seen[locale] = true
+				if err := c.loadCatalog(fsys, locale); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for locale := range seen {
+		c.locales = append(c.locales, locale)
+	}
+	sort.Strings(c.locales)
+	return c, nil
+}
+
+// layers returns the embedded content and then the override directory, so
+// that later layers win.
+func (c *Content) layers() []fs.FS {
+	layers := []fs.FS{embedded}
+	if c.dir != "" {
+		layers = append(layers, os.DirFS(c.dir))
+	}
+	return layers
+}
+
+func (c *Content) loadCatalog(fsys fs.FS, locale string) error {
+	data, err := fs.ReadFile(fsys, "catalogs/"+locale+".yml")
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s catalog", locale)
+	}
+	var cat catalog
+	if err := yaml.UnmarshalStrict(data, &cat); err != nil {
+		return errors.Wrapf(err, "failed to parse %s catalog", locale)
+	}
+
+	merged := c.catalogs[locale]
+	if merged.Messages == nil {
+		merged.Messages = map[string]string{}
+		merged.Courses = map[string]course.Translation{}
+	}
+	for key, text := range cat.Messages {
+		merged.Messages[key] = text
+	}
+	for id, t := range cat.Courses {
+		merged.Courses[id] = t
+	}
+	c.catalogs[locale] = merged
+	return nil
+}
+
+// Locales lists the languages there are templates or a catalog for, which
+// always include the default.
+func (c *Content) Locales() []string {
+	return append([]string(nil), c.locales...)
+}
+
+// Supports reports whether the language, or the one it is a variant of, has
+// any content.
+func (c *Content) Supports(lang string) bool {
+	tag := Normalize(lang)
+	if tag == "" {
+		return false
+	}
+	base := strings.SplitN(tag, "-", 2)[0]
+	for _, locale := range Chain(tag) {
+		// The default ends every chain, but only speaks for itself.
+		if locale == course.DefaultLocale && base != course.DefaultLocale {
+			return false
+		}
+		for _, l := range c.locales {
+			if l == locale {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read returns the text of the named template in the locale, and false if
+// the locale doesn't have it. The file's final newline is dropped, so that
+// messages end where their text does.
+func (c *Content) Read(locale, name string) (string, bool, error) {
+	if strings.Contains(name, "..") || strings.Contains(locale, "/") {
+		return "", false, errors.Errorf("invalid template name %s/%s", locale, name)
+	}
+
+	if c.dir != "" {
+		data, err := ioutil.ReadFile(filepath.Join(c.dir, "templates", locale, filepath.FromSlash(name)))
+		if err == nil {
+			return strings.TrimSuffix(string(data), "\n"), true, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", false, errors.Wrapf(err, "failed to read template %s/%s", locale, name)
+		}
+	}
+	data, err := embedded.ReadFile(path.Join("templates", locale, name))
+	if err != nil {
+		return "", false, nil
+	}
+	return strings.TrimSuffix(string(data), "\n"), true, nil
+}
+
+// Translation returns the course's titles and hints in the locale.
+func (c *Content) Translation(locale, courseID string) course.Translation {
+	return c.catalogs[locale].Courses[courseID]
+}
+
+// Message returns the catalog message in the locale, and false if the
+// locale's catalog doesn't have it.
+func (c *Content) Message(locale, key string) (string, bool) {
+	text, ok := c.catalogs[locale].Messages[key]
+	return text, ok
+}
+
+// Normalize returns the language tag in its usual form, such as "pt-BR" for
+// "pt_br", or "" if it doesn't look like one.
+func Normalize(lang string) string {
+	parts := strings.Split(strings.Replace(strings.TrimSpace(lang), "_", "-", -1), "-")
+	if len(parts) > 2 || len(parts[0]) < 2 || len(parts[0]) > 3 || !isLetters(parts[0]) {
+		return ""
+	}
+	tag := strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		if len(parts[1]) < 2 || len(parts[1]) > 4 || !isLetters(parts[1]) {
+			return ""
+		}
+		tag += "-" + strings.ToUpper(parts[1])
+	}
+	return tag
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Chain returns the locales to try for a language, most specific first and
+// ending with the default: "de-AT" gives de-AT, de, en.
+func Chain(lang string) []string {
+	var chain []string
+	if tag := Normalize(lang); tag != "" {
+		chain = append(chain, tag)
+		if i := strings.Index(tag, "-"); i > 0 {
+			chain = append(chain, tag[:i])
+		}
+	}
+	if len(chain) == 0 || chain[len(chain)-1] != course.DefaultLocale {
+		chain = append(chain, course.DefaultLocale)
+	}
+	return chain
+}
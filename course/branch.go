@@ -0,0 +1,109 @@
+package course
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BranchNames is a branch naming convention. Names follow either a glob, in
+// which * and ? match anything but a "/", or a regular expression, matched
+// against the whole name. Both can use {{login}}, the trainee's login, and
+// {{n}}, a number. Reserved names, which may also be globs, are never
+// allowed. Case doesn't matter unless CaseSensitive is set.
+type BranchNames struct {
+	Glob          string   `yaml:"glob"`
+	Regex         string   `yaml:"regex"`
+	Reserved      []string `yaml:"reserved"`
+	CaseSensitive bool     `yaml:"case_sensitive"`
+}
+
+const (
+	loginVar  = "{{login}}"
+	numberVar = "{{n}}"
+)
+
+func (b BranchNames) validate() error {
+	if (b.Glob == "") == (b.Regex == "") {
+		return errors.New("branch names need either a glob or a regex")
+	}
+	if _, err := b.compile("octocat"); err != nil {
+		return err
+	}
+	for _, name := range b.Reserved {
+		if _, err := path.Match(name, ""); err != nil {
+			return errors.Wrapf(err, "reserved branch name %q", name)
+		}
+	}
+	return nil
+}
+
+// compile returns the convention for the trainee as a regular expression.
+func (b BranchNames) compile(login string) (*regexp.Regexp, error) {
+	expr := strings.NewReplacer(loginVar, regexp.QuoteMeta(login), numberVar, `[0-9]+`).Replace(b.Regex)
+	if b.Glob != "" {
+		var sb strings.Builder
+		for rest := b.Glob; rest != ""; {
+			switch {
+			case strings.HasPrefix(rest, loginVar):
+				sb.WriteString(regexp.QuoteMeta(login))
+				rest = rest[len(loginVar):]
+				continue
+			case strings.HasPrefix(rest, numberVar):
+				sb.WriteString(`[0-9]+`)
+				rest = rest[len(numberVar):]
+				continue
+			case rest[0] == '*':
+				sb.WriteString(`[^/]*`)
+			case rest[0] == '?':
+				sb.WriteString(`[^/]`)
+			default:
+				sb.WriteString(regexp.QuoteMeta(rest[:1]))
+			}
+			rest = rest[1:]
+		}
+		expr = sb.String()
+	}
+	expr = "^(?:" + expr + ")$"
+	if !b.CaseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	return re, errors.Wrap(err, "invalid branch name convention")
+}
+
+// Check reports whether the trainee's branch follows the convention. The
+// check describes the convention in Expected and, for a glob, gives a name
+// that follows it in Example.
+func (b BranchNames) Check(login, branch string) (Check, bool, error) {
+	check := Check{Content: branch}
+	if b.Glob != "" {
+		check.Expected = strings.NewReplacer(loginVar, login, numberVar, "<n>").Replace(b.Glob)
+		if !strings.ContainsAny(b.Glob, "*?") {
+			check.Example = strings.NewReplacer(loginVar, login, numberVar, "1").Replace(b.Glob)
+		}
+	} else {
+		check.Expected = strings.Replace(b.Regex, loginVar, login, -1)
+	}
+
+	fold := func(s string) string {
+		if b.CaseSensitive {
+			return s
+		}
+		return strings.ToLower(s)
+	}
+	for _, name := range b.Reserved {
+		if ok, _ := path.Match(fold(name), fold(branch)); ok {
+			check.Reserved = true
+			return check, false, nil
+		}
+	}
+
+	re, err := b.compile(login)
+	if err != nil {
+		return check, false, err
+	}
+	return check, re.MatchString(branch), nil
+}
@@ -0,0 +1,46 @@
+package course
+
+import "testing"
+
+func TestBranchNames(t *testing.T) {
+	glob := BranchNames{Glob: "feat/{{login}}-{{n}}"}
+	exact := BranchNames{Glob: "feat/{{login}}-{{n}}", CaseSensitive: true}
+	reserved := BranchNames{Regex: ".+", Reserved: []string{"main", "release/*"}}
+	exactReserved := BranchNames{Regex: ".+", Reserved: []string{"main", "release/*"}, CaseSensitive: true}
+	regex := BranchNames{Regex: `{{login}}/.+`}
+
+	for _, tc := range []struct {
+		names    BranchNames
+		login    string
+		branch   string
+		ok       bool
+		reserved bool
+	}{
+		{glob, "Octocat", "feat/octocat-1", true, false},
+		{glob, "octocat", "FEAT/OCTOCAT-12", true, false},
+		{glob, "octocat", "Feat/Octocat-1", true, false},
+		{glob, "octocat", "feat/octocat-x", false, false},
+		{glob, "octocat", "feat/octocat-1/more", false, false},
+		{glob, "octocat", "feat/monalisa-1", false, false},
+		{exact, "octocat", "feat/octocat-1", true, false},
+		{exact, "Octocat", "feat/Octocat-1", true, false},
+		{exact, "octocat", "Feat/Octocat-1", false, false},
+		{reserved, "octocat", "MAIN", false, true},
+		{reserved, "octocat", "Release/1.0", false, true},
+		{reserved, "octocat", "release/1.0/fix", true, false},
+		{reserved, "octocat", "mainline", true, false},
+		{exactReserved, "octocat", "main", false, true},
+		{exactReserved, "octocat", "MAIN", true, false},
+		{regex, "mona.lisa", "Mona.Lisa/work", true, false},
+		{regex, "mona.lisa", "monaXlisa/work", false, false},
+	} {
+		check, ok, err := tc.names.Check(tc.login, tc.branch)
+		if err != nil {
+			t.Errorf("%+v: %s for %s: %v", tc.names, tc.branch, tc.login, err)
+			continue
+		}
+		if ok != tc.ok || check.Reserved != tc.reserved {
+			t.Errorf("%+v: %s for %s: got ok %t, reserved %t; want %t, %t", tc.names, tc.branch, tc.login, ok, check.Reserved, tc.ok, tc.reserved)
+		}
+	}
+}
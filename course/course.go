@@ -0,0 +1,532 @@
+package course
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	tparse "text/template/parse"
+
+	"github.com/ctrlaltdel121/configor"
+	"github.com/pkg/errors"
+)
+
+// Course is a declarative lesson plan. Each step waits for a webhook event,
+// validates it and then performs the actions that lead the trainee on to the
+// next step. OutOfOrder actions are performed when a trainee triggers a step
+// they haven't reached yet.
+type Course struct {
+	ID         string   `yaml:"id"`
+	Title      string   `yaml:"title"`
+	Steps      []Step   `yaml:"steps"`
+	OutOfOrder []Action `yaml:"out_of_order"`
+	// Videos are the videos messages link to, by name. Deployments can
+	// point them elsewhere.
+	Videos map[string]string `yaml:"videos"`
+
+	// Titles holds the course's title in other languages, by locale.
+	Titles map[string]string `yaml:"-"`
+}
+
+// Step is one task in a course. Hints are handed out one at a time, each more
+// detailed than the last, when a trainee asks for one; optional steps can be
+// skipped.
+type Step struct {
+	ID          string       `yaml:"id"`
+	Title       string       `yaml:"title"`
+	Optional    bool         `yaml:"optional"`
+	Hints       []string     `yaml:"hints"`
+	On          Trigger      `yaml:"on"`
+	Validations []Validation `yaml:"validate"`
+	Actions     []Action     `yaml:"do"`
+
+	// Titles and LocalizedHints hold the step's text in other languages, by
+	// locale.
+	Titles         map[string]string   `yaml:"-"`
+	LocalizedHints map[string][]string `yaml:"-"`
+}
+
+// Trigger selects the webhook deliveries that complete a step. Actions holds
+// the webhook "action" values (or the ref type for create events); an empty
+// list matches every delivery of the event type.
+type Trigger struct {
+	Event   string   `yaml:"event"`
+	Actions []string `yaml:"actions"`
+}
+
+// Validation is a check an event must pass to complete a step. When it
+// fails, its Fail actions tell the trainee what's wrong; they can use
+// {{.Check}} to say what the check found.
+type Validation struct {
+	Type  string `yaml:"type"`
+	Text  string `yaml:"text"`
+	Path  string `yaml:"path"`
+	Line  int    `yaml:"line"`
+	Count int    `yaml:"count"`
+	// Branch is the naming convention a branch_name validation checks.
+	Branch BranchNames `yaml:"branch"`
+	Fail   []Action    `yaml:"fail"`
+}
+
+// Action is something the bot posts. Its body is either given inline or
+// read from the named template in the content directory, which may also have
+// it in other languages.
+type Action struct {
+	Type     string          `yaml:"type"`
+	Target   string          `yaml:"target"`
+	Event    string          `yaml:"event"`
+	Body     string          `yaml:"body"`
+	Template string          `yaml:"template"`
+	Comments []ReviewComment `yaml:"comments"`
+
+	// Bodies holds the body in other languages, by locale.
+	Bodies map[string]string `yaml:"-"`
+}
+
+// ReviewComment is a comment on a line of a file in a review. The line is
+// given as a line number in the file, which the engine finds in the pull
+// request's diff, or as a position in the diff itself.
+type ReviewComment struct {
+	Path     string `yaml:"path"`
+	Line     int    `yaml:"line"`
+	Position int    `yaml:"position"`
+	Body     string `yaml:"body"`
+	Template string `yaml:"template"`
+
+	Bodies map[string]string `yaml:"-"`
+}
+
+// Vars are the values available to the templates in a course definition.
+// WebURL, DocsURL and Videos are where links go, which differ on GitHub
+// Enterprise Server.
+type Vars struct {
+	Trainee       string
+	Owner         string
+	Repo          string
+	Branch        string
+	DefaultBranch string
+	Step          string
+	IssueNumber   int
+	PRNumber      int
+	WebURL        string
+	DocsURL       string
+	Videos        map[string]string
+	Check         Check
+}
+
+// Check is what a failed validation found, for its fail actions.
+type Check struct {
+	// Path is the file it looked at, and Expected what should be in it.
+	Path     string
+	Expected string
+	// Found reports whether the file was there, and Content is what it
+	// holds. For a pull request that doesn't close the trainee's issue,
+	// Found reports whether its body mentions the issue anyway.
+	Found   bool
+	Content string
+	// Line is the line it looked at, counting from 1, when it checks a
+	// line rather than the whole file; Content is then that line.
+	Line int
+	// Changed lists the files the event changed.
+	Changed []string
+	// For a branch name, Content is the name, Expected the convention it
+	// should follow and Example a name that does, if there's one to give.
+	// Reserved reports whether the name is one that's never allowed.
+	Example  string
+	Reserved bool
+}
+
+const (
+	ValidateAssigneeIsAuthor = "assignee_is_author"
+	ValidateBodyContains     = "body_contains"
+	ValidateClosesIssue      = "closes_issue"
+	ValidateMinCommits       = "min_commits"
+	ValidateFileContent      = "file_content"
+	ValidateLineChanged      = "line_changed"
+	ValidateBranchName       = "branch_name"
+
+	ActionComment = "comment"
+	ActionReview  = "review"
+	ActionApprove = "approve"
+
+	TargetIssue       = "issue"
+	TargetPullRequest = "pull_request"
+)
+
+var knownEvents = map[string]bool{
+	"issues":       true,
+	"create":       true,
+	"push":         true,
+	"pull_request": true,
+}
+
+// Load reads a course definition from a YAML file, fills in the bodies of
+// actions that name a template and the translations the content has, and
+// validates it.
+func Load(path string, content Content) (*Course, error) {
+	var c Course
+	if err := configor.Load(&c, path); err != nil {
+		return nil, errors.Wrapf(err, "failed to load course %s", path)
+	}
+	if err := c.resolve(content); err != nil {
+		return nil, errors.Wrapf(err, "invalid course %s", path)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid course %s", path)
+	}
+	return &c, nil
+}
+
+// Validate checks that the course only refers to events, validations and
+// actions the engine knows about, and that every template parses.
+func (c *Course) Validate() error {
+	if c.ID == "" {
+		return errors.New("course has no id")
+	}
+	if len(c.Steps) == 0 {
+		return errors.New("course has no steps")
+	}
+
+	seen := map[string]bool{}
+	for _, step := range c.Steps {
+		if step.ID == "" {
+			return errors.New("step has no id")
+		}
+		if seen[step.ID] {
+			return errors.Errorf("duplicate step %s", step.ID)
+		}
+		seen[step.ID] = true
+
+		if !knownEvents[step.On.Event] {
+			return errors.Errorf("step %s: unknown event %q", step.ID, step.On.Event)
+		}
+		hints := append([]string(nil), step.Hints...)
+		for _, localized := range step.LocalizedHints {
+			hints = append(hints, localized...)
+		}
+		for _, h := range hints {
+			if _, err := parse(h); err != nil {
+				return errors.Wrapf(err, "step %s", step.ID)
+			}
+		}
+		for _, v := range step.Validations {
+			switch v.Type {
+			case ValidateAssigneeIsAuthor, ValidateClosesIssue, ValidateMinCommits:
+			case ValidateBodyContains:
+				if _, err := parse(v.Text); err != nil {
+					return errors.Wrapf(err, "step %s", step.ID)
+				}
+			case ValidateFileContent, ValidateLineChanged:
+				if v.Path == "" {
+					return errors.Errorf("step %s: %s validation has no path", step.ID, v.Type)
+				}
+				if v.Type == ValidateLineChanged && v.Line < 1 {
+					return errors.Errorf("step %s: %s validation needs a line, counting from 1", step.ID, v.Type)
+				}
+				for _, t := range []string{v.Path, v.Text} {
+					if _, err := parse(t); err != nil {
+						return errors.Wrapf(err, "step %s", step.ID)
+					}
+				}
+			case ValidateBranchName:
+				if err := v.Branch.validate(); err != nil {
+					return errors.Wrapf(err, "step %s", step.ID)
+				}
+			default:
+				return errors.Errorf("step %s: unknown validation %q", step.ID, v.Type)
+			}
+			for _, a := range v.Fail {
+				if err := a.validate(); err != nil {
+					return errors.Wrapf(err, "step %s: %s validation", step.ID, v.Type)
+				}
+			}
+		}
+		for _, a := range step.Actions {
+			if err := a.validate(); err != nil {
+				return errors.Wrapf(err, "step %s", step.ID)
+			}
+		}
+	}
+	for _, a := range c.OutOfOrder {
+		if err := a.validate(); err != nil {
+			return errors.Wrap(err, "out_of_order")
+		}
+	}
+	return c.checkVideos()
+}
+
+func (a Action) validate() error {
+	switch a.Type {
+	case ActionComment, ActionReview, ActionApprove:
+	default:
+		return errors.Errorf("unknown action %q", a.Type)
+	}
+	switch a.Target {
+	case TargetIssue, TargetPullRequest:
+	default:
+		return errors.Errorf("unknown target %q", a.Target)
+	}
+	if a.Type != ActionComment && a.Target != TargetPullRequest {
+		return errors.Errorf("%s action must target a pull_request", a.Type)
+	}
+
+	templates := []string{a.Body}
+	for _, body := range a.Bodies {
+		templates = append(templates, body)
+	}
+	for _, c := range a.Comments {
+		if (c.Line > 0) == (c.Position > 0) {
+			return errors.Errorf("review comment on %s needs either a line or a position", c.Path)
+		}
+		templates = append(templates, c.Path, c.Body)
+		for _, body := range c.Bodies {
+			templates = append(templates, body)
+		}
+	}
+	for _, t := range templates {
+		if _, err := parse(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step returns the step with the given id.
+func (c *Course) Step(id string) (Step, bool) {
+	if i := c.Index(id); i >= 0 {
+		return c.Steps[i], true
+	}
+	return Step{}, false
+}
+
+// Index returns the position of the step with the given id, or -1.
+func (c *Course) Index(id string) int {
+	for i, step := range c.Steps {
+		if step.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Next returns the id of the step after the given one, or "" if it is the
+// last step of the course.
+func (c *Course) Next(id string) string {
+	if i := c.Index(id); i >= 0 && i+1 < len(c.Steps) {
+		return c.Steps[i+1].ID
+	}
+	return ""
+}
+
+// Triggered returns the steps whose trigger matches the given event.
+func (c *Course) Triggered(event, action string) []Step {
+	var steps []Step
+	for _, step := range c.Steps {
+		if step.On.Matches(event, action) {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+func (t Trigger) Matches(event, action string) bool {
+	if t.Event != event {
+		return false
+	}
+	if len(t.Actions) == 0 {
+		return true
+	}
+	for _, a := range t.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Render executes a template from the course definition with the given vars.
+func Render(text string, vars Vars) (string, error) {
+	t, err := parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", errors.Wrap(err, "failed to render template")
+	}
+	return buf.String(), nil
+}
+
+func parse(text string) (*template.Template, error) {
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %q: %s", abbreviate(text), err)
+	}
+	if err := checkFields(t.Tree.Root); err != nil {
+		return nil, fmt.Errorf("template %q: %s", abbreviate(text), err)
+	}
+	return t, nil
+}
+
+// checkFields walks a template looking for fields that Vars doesn't have,
+// which would otherwise only fail when the template is rendered, and only if
+// the branch using them runs. Inside with and range, fields are looked up on
+// what dot is there.
+func checkFields(node tparse.Node) error {
+	return walkFields(node, func(n *tparse.FieldNode, dot scope) error {
+		if !dot.known {
+			return nil
+		}
+		t := reflect.TypeOf(Vars{})
+		for i, name := range append(append([]string(nil), dot.path...), n.Ident...) {
+			var ok bool
+			if t, ok = fieldType(t, name); !ok {
+				if len(dot.path) == 0 && i == 0 {
+					return errors.Errorf("unknown variable .%s", name)
+				}
+				if len(dot.path) == 0 {
+					return errors.Errorf("unknown field %s in %s", name, n)
+				}
+				return errors.Errorf("unknown field %s in %s, where dot is .%s", name, n, strings.Replace(strings.Join(dot.path, "."), ".[]", "[]", -1))
+			}
+			if t == nil {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Fields returns the names of the fields of its data that a template uses,
+// in the order it first uses them. Fields of whatever dot is inside a with or
+// range aren't included.
+func Fields(t *template.Template) []string {
+	var names []string
+	seen := map[string]bool{}
+	walkFields(t.Tree.Root, func(n *tparse.FieldNode, dot scope) error {
+		if dot.known && len(dot.path) == 0 && !seen[n.Ident[0]] {
+			seen[n.Ident[0]] = true
+			names = append(names, n.Ident[0])
+		}
+		return nil
+	})
+	return names
+}
+
+// fieldType returns the type of the field of t, an element of t if name is
+// "[]", or nil if t is an interface and can't be looked into.
+func fieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Interface:
+		return nil, true
+	case name == "[]":
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return t.Elem(), true
+		}
+	case t.Kind() == reflect.Map:
+		return t.Elem(), true
+	case t.Kind() == reflect.Struct:
+		if f, ok := t.FieldByName(name); ok {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+// scope is what dot is in part of a template: the path of fields to it from
+// the template's data, with "[]" for the elements of a range. Dot isn't known
+// inside with and range over anything but a field.
+type scope struct {
+	path  []string
+	known bool
+}
+
+// narrow returns the scope of the body of a with, or of a range if elements
+// is set, over the pipeline.
+func (s scope) narrow(pipe *tparse.PipeNode, elements bool) scope {
+	if !s.known || pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return scope{}
+	}
+	path := append([]string(nil), s.path...)
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *tparse.DotNode:
+	case *tparse.FieldNode:
+		path = append(path, arg.Ident...)
+	default:
+		return scope{}
+	}
+	if elements {
+		path = append(path, "[]")
+	}
+	return scope{path: path, known: true}
+}
+
+// walkFields calls fn with every field a template uses and the scope it's
+// used in.
+func walkFields(node tparse.Node, fn func(*tparse.FieldNode, scope) error) error {
+	return walkScope(node, scope{known: true}, fn)
+}
+
+func walkScope(node tparse.Node, dot scope, fn func(*tparse.FieldNode, scope) error) error {
+	switch n := node.(type) {
+	case *tparse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := walkScope(child, dot, fn); err != nil {
+				return err
+			}
+		}
+	case *tparse.ActionNode:
+		return walkScope(n.Pipe, dot, fn)
+	case *tparse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := walkScope(cmd, dot, fn); err != nil {
+				return err
+			}
+		}
+	case *tparse.CommandNode:
+		for _, arg := range n.Args {
+			if err := walkScope(arg, dot, fn); err != nil {
+				return err
+			}
+		}
+	case *tparse.IfNode:
+		return walkBranch(&n.BranchNode, dot, dot, fn)
+	case *tparse.RangeNode:
+		return walkBranch(&n.BranchNode, dot, dot.narrow(n.Pipe, true), fn)
+	case *tparse.WithNode:
+		return walkBranch(&n.BranchNode, dot, dot.narrow(n.Pipe, false), fn)
+	case *tparse.FieldNode:
+		return fn(n, dot)
+	}
+	return nil
+}
+
+// walkBranch walks an if, range or with, whose body has dot set to inner.
+func walkBranch(n *tparse.BranchNode, dot, inner scope, fn func(*tparse.FieldNode, scope) error) error {
+	if err := walkScope(n.Pipe, dot, fn); err != nil {
+		return err
+	}
+	if err := walkScope(n.List, inner, fn); err != nil {
+		return err
+	}
+	return walkScope(n.ElseList, dot, fn)
+}
+
+func abbreviate(s string) string {
+	if len(s) > 40 {
+		return s[:40] + "..."
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package course
+
+import (
+	tparse "text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// Links are where messages link to outside the trainee's repository. On
+// GitHub Enterprise Server they point at the server rather than github.com.
+type Links struct {
+	// WebURL is the address of the GitHub web interface, such as
+	// https://github.com, which repository links are built on.
+	WebURL  string            `yaml:"-"`
+	DocsURL string            `yaml:"docs_url" default:"https://help.github.com"`
+	Videos  map[string]string `yaml:"videos"`
+}
+
+// Links returns the links to use for the course: the given ones, with the
+// course's own videos where they don't name another address.
+func (c *Course) Links(overrides Links) (Links, error) {
+	links := overrides
+	links.Videos = map[string]string{}
+	for name, url := range c.Videos {
+		links.Videos[name] = url
+	}
+	for name, url := range overrides.Videos {
+		if _, ok := c.Videos[name]; !ok {
+			return Links{}, errors.Errorf("course %s has no video %s", c.ID, name)
+		}
+		links.Videos[name] = url
+	}
+	return links, nil
+}
+
+// checkVideos makes sure every video the course's templates link to is one
+// it lists.
+func (c *Course) checkVideos() error {
+	for _, text := range c.templates() {
+		t, err := parse(text)
+		if err != nil {
+			return err
+		}
+		err = walkFields(t.Tree.Root, func(n *tparse.FieldNode, dot scope) error {
+			path := append(append([]string(nil), dot.path...), n.Ident...)
+			if !dot.known || path[0] != "Videos" {
+				return nil
+			}
+			if len(path) < 2 || path[1] == "[]" {
+				return errors.Errorf("template %q uses .Videos without naming a video", abbreviate(text))
+			}
+			if _, ok := c.Videos[path[1]]; !ok {
+				return errors.Errorf("template %q links to video %s, which the course doesn't list", abbreviate(text), path[1])
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templates returns the text of every template in the course, in every
+// language.
+func (c *Course) templates() []string {
+	var texts []string
+	action := func(a Action) {
+		texts = append(texts, a.Body)
+		for _, body := range a.Bodies {
+			texts = append(texts, body)
+		}
+		for _, rc := range a.Comments {
+			texts = append(texts, rc.Path, rc.Body)
+			for _, body := range rc.Bodies {
+				texts = append(texts, body)
+			}
+		}
+	}
+	for _, step := range c.Steps {
+		texts = append(texts, step.Hints...)
+		for _, hints := range step.LocalizedHints {
+			texts = append(texts, hints...)
+		}
+		for _, v := range step.Validations {
+			texts = append(texts, v.Text, v.Path)
+			for _, a := range v.Fail {
+				action(a)
+			}
+		}
+		for _, a := range step.Actions {
+			action(a)
+		}
+	}
+	for _, a := range c.OutOfOrder {
+		action(a)
+	}
+	return texts
+}
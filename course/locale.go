@@ -0,0 +1,209 @@
+package course
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultLocale is the language courses are written in, and the one every
+// other language falls back to.
+const DefaultLocale = "en"
+
+// Content supplies the message templates that actions name, and the
+// translations of a course's text.
+type Content interface {
+	// Locales lists the languages there is content for.
+	Locales() []string
+	// Read returns the named template in the locale, and false if the
+	// locale doesn't have it.
+	Read(locale, name string) (string, bool, error)
+	// Translation returns the course's titles and hints in the locale.
+	Translation(locale, course string) Translation
+}
+
+// Translation is a course's titles and hints in another language. Steps are
+// keyed by id.
+type Translation struct {
+	Title string                     `yaml:"title"`
+	Steps map[string]StepTranslation `yaml:"steps"`
+}
+
+type StepTranslation struct {
+	Title string   `yaml:"title"`
+	Hints []string `yaml:"hints"`
+}
+
+// resolve reads the templates that actions name, in the default locale and
+// in every other locale that has them, and fills in the translations of the
+// course's titles and hints.
+func (c *Course) resolve(content Content) error {
+	read := func(body *string, bodies *map[string]string, name string) error {
+		if name == "" {
+			return nil
+		}
+		if *body != "" {
+			return errors.Errorf("template %s and an inline body are both given", name)
+		}
+		text, ok, err := content.Read(DefaultLocale, name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.Errorf("no template %s", name)
+		}
+		*body = text
+
+		for _, locale := range content.Locales() {
+			if locale == DefaultLocale {
+				continue
+			}
+			text, ok, err := content.Read(locale, name)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if *bodies == nil {
+					*bodies = map[string]string{}
+				}
+				(*bodies)[locale] = text
+			}
+		}
+		return nil
+	}
+	resolveAction := func(a *Action) error {
+		if err := read(&a.Body, &a.Bodies, a.Template); err != nil {
+			return err
+		}
+		for i := range a.Comments {
+			if err := read(&a.Comments[i].Body, &a.Comments[i].Bodies, a.Comments[i].Template); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := range c.Steps {
+		step := &c.Steps[i]
+		for j := range step.Actions {
+			if err := resolveAction(&step.Actions[j]); err != nil {
+				return errors.Wrapf(err, "step %s", step.ID)
+			}
+		}
+		for j := range step.Validations {
+			for k := range step.Validations[j].Fail {
+				if err := resolveAction(&step.Validations[j].Fail[k]); err != nil {
+					return errors.Wrapf(err, "step %s: %s validation", step.ID, step.Validations[j].Type)
+				}
+			}
+		}
+	}
+	for i := range c.OutOfOrder {
+		if err := resolveAction(&c.OutOfOrder[i]); err != nil {
+			return errors.Wrap(err, "out_of_order")
+		}
+	}
+
+	for _, locale := range content.Locales() {
+		if locale == DefaultLocale {
+			continue
+		}
+		t := content.Translation(locale, c.ID)
+		if t.Title != "" {
+			if c.Titles == nil {
+				c.Titles = map[string]string{}
+			}
+			c.Titles[locale] = t.Title
+		}
+		for id, st := range t.Steps {
+			i := c.Index(id)
+			if i < 0 {
+				return errors.Errorf("%s translation has unknown step %s", locale, id)
+			}
+			step := &c.Steps[i]
+			if st.Title != "" {
+				if step.Titles == nil {
+					step.Titles = map[string]string{}
+				}
+				step.Titles[locale] = st.Title
+			}
+			if len(st.Hints) > 0 {
+				if step.LocalizedHints == nil {
+					step.LocalizedHints = map[string][]string{}
+				}
+				step.LocalizedHints[locale] = st.Hints
+			}
+		}
+	}
+	return nil
+}
+
+// TitleIn returns the course's title in the first of the locales that has
+// one.
+func (c *Course) TitleIn(locales []string) string {
+	return pick(c.Titles, locales, c.Title)
+}
+
+// TitleIn returns the step's title in the first of the locales that has one.
+func (s Step) TitleIn(locales []string) string {
+	return pick(s.Titles, locales, s.Title)
+}
+
+// HintsIn returns the step's hints in the first of the locales that has any.
+func (s Step) HintsIn(locales []string) []string {
+	for _, locale := range locales {
+		if hints := s.LocalizedHints[locale]; len(hints) > 0 {
+			return hints
+		}
+	}
+	return s.Hints
+}
+
+// Texts returns the action's body in each of the locales that has it, in
+// order, ending with the default.
+func (a Action) Texts(locales []string) []string {
+	return texts(a.Bodies, locales, a.Body)
+}
+
+// Texts returns the comment's body in each of the locales that has it, in
+// order, ending with the default.
+func (c ReviewComment) Texts(locales []string) []string {
+	return texts(c.Bodies, locales, c.Body)
+}
+
+func pick(translations map[string]string, locales []string, fallback string) string {
+	for _, locale := range locales {
+		if t := translations[locale]; strings.TrimSpace(t) != "" {
+			return t
+		}
+	}
+	return fallback
+}
+
+func texts(translations map[string]string, locales []string, fallback string) []string {
+	var out []string
+	for _, locale := range locales {
+		if t, ok := translations[locale]; ok {
+			out = append(out, t)
+		}
+	}
+	return append(out, fallback)
+}
+
+// RenderFirst renders the first of the texts that renders to something other
+// than blank, so that a broken or empty translation falls back to the next
+// language rather than posting an empty comment.
+func RenderFirst(texts []string, vars Vars) (string, error) {
+	var err error
+	for _, text := range texts {
+		var out string
+		out, err = Render(text, vars)
+		if err == nil && strings.TrimSpace(out) != "" {
+			return out, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", errors.New("every translation is blank")
+}
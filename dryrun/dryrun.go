@@ -0,0 +1,184 @@
+// Package dryrun keeps the bot from changing anything on GitHub. Its client
+// middleware lets reads through and answers every write itself, recording it
+// in the log and in a journal instead.
+package dryrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is a write the bot would have made.
+type Entry struct {
+	Time   time.Time       `json:"time"`
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Kind   string          `json:"kind"`
+	Event  string          `json:"event,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Journal holds the most recent writes, oldest first.
+type Journal struct {
+	mu      sync.Mutex
+	size    int
+	entries []Entry
+}
+
+// NewJournal returns a journal that keeps the last size writes, or every
+// write when size is 0.
+func NewJournal(size int) *Journal {
+	return &Journal{size: size}
+}
+
+// Entries returns the writes in the journal, oldest first.
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Entry(nil), j.entries...)
+}
+
+func (j *Journal) add(e Entry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, e)
+	if j.size > 0 && len(j.entries) > j.size {
+		j.entries = append([]Entry(nil), j.entries[len(j.entries)-j.size:]...)
+	}
+}
+
+// Middleware is a githubapp.ClientMiddleware that sends reads on to next and
+// journals writes without sending them.
+func (j *Journal) Middleware(next http.RoundTripper) http.RoundTripper {
+	return roundTripper{j, next}
+}
+
+type roundTripper struct {
+	j    *Journal
+	next http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return rt.next.RoundTrip(r)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// GraphQL queries are POSTs too.
+	if isQuery(r, body) {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return rt.next.RoundTrip(r)
+	}
+
+	e := Entry{Time: time.Now().UTC(), Method: r.Method, Path: r.URL.Path, Kind: kind(r.URL.Path)}
+	if json.Valid(body) {
+		e.Body = body
+	}
+	var review struct {
+		Event string `json:"event"`
+	}
+	if e.Kind == "review" && json.Unmarshal(body, &review) == nil {
+		e.Event = review.Event
+	}
+	rt.j.add(e)
+	logrus.WithFields(logrus.Fields{
+		"method": e.Method,
+		"path":   e.Path,
+		"kind":   e.Kind,
+		"event":  e.Event,
+	}).Infof("Dry run: not sending %s\n%s", e.Kind, summary(body))
+
+	return respond(r, body), nil
+}
+
+func isQuery(r *http.Request, body []byte) bool {
+	if !strings.HasSuffix(r.URL.Path, "/graphql") {
+		return false
+	}
+	var q struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(body, &q)
+	return !strings.HasPrefix(strings.TrimSpace(q.Query), "mutation")
+}
+
+var kinds = []struct {
+	pattern *regexp.Regexp
+	kind    string
+}{
+	{regexp.MustCompile(`/issues/\d+/comments$`), "comment"},
+	{regexp.MustCompile(`/pulls/\d+/reviews$`), "review"},
+	{regexp.MustCompile(`/issues/\d+/labels(/[^/]+)?$`), "labels"},
+	{regexp.MustCompile(`/graphql$`), "mutation"},
+}
+
+func kind(path string) string {
+	for _, k := range kinds {
+		if k.pattern.MatchString(path) {
+			return k.kind
+		}
+	}
+	return "other"
+}
+
+// summary returns the body of a comment or review, or the whole request when
+// it has none.
+func summary(body []byte) string {
+	var v struct {
+		Body string `json:"body"`
+	}
+	if json.Unmarshal(body, &v) == nil && v.Body != "" {
+		return v.Body
+	}
+	return string(body)
+}
+
+// respond makes up the response GitHub would have sent: the request echoed
+// back, which is enough for the fields the bot reads.
+func respond(r *http.Request, body []byte) *http.Response {
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		status = http.StatusCreated
+	}
+
+	out := body
+	var labels []string
+	switch {
+	case r.Method == http.MethodDelete || len(body) == 0:
+		status, out = http.StatusNoContent, nil
+	case json.Unmarshal(body, &labels) == nil:
+		var named []map[string]string
+		for _, l := range labels {
+			named = append(named, map[string]string{"name": l})
+		}
+		out, _ = json.Marshal(named)
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(out)),
+		ContentLength: int64(len(out)),
+		Request:       r,
+	}
+}
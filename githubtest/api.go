@@ -0,0 +1,660 @@
+package githubtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handle  func(s *Server, w http.ResponseWriter, r *http.Request, m []string)
+}
+
+func handle(method, pattern string, h func(s *Server, w http.ResponseWriter, r *http.Request, m []string)) route {
+	return route{method, regexp.MustCompile("^" + pattern + "$"), h}
+}
+
+const repoPath = `/repos/([^/]+)/([^/]+)`
+
+var routes = []route{
+	handle("GET", `/app`, getApp),
+	handle("GET", `/app/installations`, listInstallations),
+	handle("GET", `/installation/repositories`, listInstallationRepos),
+	handle("GET", repoPath, getRepo),
+	handle("GET", repoPath+`/branches`, listBranches),
+	handle("GET", repoPath+`/git/refs/(.+)`, getRef),
+	handle("GET", repoPath+`/compare/(.+)\.\.\.(.+)`, compareCommits),
+	handle("GET", repoPath+`/contents/(.+)`, getContents),
+	handle("GET", repoPath+`/collaborators/([^/]+)/permission`, getPermissionLevel),
+	handle("GET", repoPath+`/issues`, listIssues),
+	handle("GET", repoPath+`/issues/(\d+)`, getIssue),
+	handle("GET", repoPath+`/issues/(\d+)/comments`, listComments),
+	handle("POST", repoPath+`/issues/(\d+)/comments`, createComment),
+	handle("POST", repoPath+`/issues/(\d+)/labels`, addLabels),
+	handle("GET", repoPath+`/pulls`, listPulls),
+	handle("GET", repoPath+`/pulls/(\d+)`, getPull),
+	handle("GET", repoPath+`/pulls/(\d+)/files`, listPullFiles),
+	handle("GET", repoPath+`/pulls/(\d+)/reviews`, listReviews),
+	handle("POST", repoPath+`/pulls/(\d+)/reviews`, createReview),
+	handle("POST", `/graphql`, graphql),
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, route := range routes {
+		if m := route.pattern.FindStringSubmatch(r.URL.Path); m != nil && route.method == r.Method {
+			route.handle(s, w, r, m)
+			return
+		}
+	}
+	fail(w, http.StatusNotFound, "Not Found")
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// page returns the bounds of the page of n items the request asks for, and
+// links to the next page, if there is one, as GitHub does. Pages hold 30
+// items unless the request sets per_page, up to 100.
+func page(w http.ResponseWriter, r *http.Request, n int) (start, end int) {
+	q := r.URL.Query()
+	size, _ := strconv.Atoi(q.Get("per_page"))
+	if size <= 0 {
+		size = 30
+	} else if size > 100 {
+		size = 100
+	}
+	number, _ := strconv.Atoi(q.Get("page"))
+	if number <= 0 {
+		number = 1
+	}
+
+	start, end = (number-1)*size, number*size
+	if start > n {
+		start = n
+	}
+	if end >= n {
+		return start, n
+	}
+	q.Set("page", strconv.Itoa(number+1))
+	next := *r.URL
+	next.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	return start, end
+}
+
+func fail(w http.ResponseWriter, status int, message string) {
+	reply(w, status, map[string]string{"message": message})
+}
+
+// repo returns the repository named by the route, writing a 404 if there is
+// none.
+func (s *Server) repo(w http.ResponseWriter, m []string) *Repo {
+	r := s.repos[m[1]+"/"+m[2]]
+	if r == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+	}
+	return r
+}
+
+// issue returns the issue or pull request numbered by the route's third
+// group, writing a 404 if there is none.
+func (s *Server) issue(w http.ResponseWriter, m []string) (*Repo, *Issue) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return nil, nil
+	}
+	n, _ := strconv.Atoi(m[3])
+	issue := repo.issue(n)
+	if issue == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+	}
+	return repo, issue
+}
+
+func user(login string) *github.User {
+	t := "User"
+	if login == BotLogin {
+		t = "Bot"
+	}
+	return &github.User{Login: github.String(login), Type: github.String(t)}
+}
+
+func timestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (r *Repo) toGitHub() *github.Repository {
+	return &github.Repository{
+		ID:            github.Int64(r.ID),
+		Name:          github.String(r.Name),
+		FullName:      github.String(r.fullName()),
+		Owner:         user(r.Owner),
+		DefaultBranch: github.String(r.DefaultBranch),
+	}
+}
+
+func (i *Issue) toGitHub() *github.Issue {
+	issue := &github.Issue{
+		Number:    github.Int(i.Number),
+		Title:     github.String(i.Title),
+		Body:      github.String(i.Body),
+		State:     github.String(i.State),
+		User:      user(i.User),
+		CreatedAt: timestamp(i.CreatedAt),
+		UpdatedAt: timestamp(i.UpdatedAt),
+		ClosedAt:  timestamp(i.ClosedAt),
+	}
+	for _, l := range i.Labels {
+		issue.Labels = append(issue.Labels, github.Label{Name: github.String(l)})
+	}
+	for _, a := range i.Assignees {
+		issue.Assignees = append(issue.Assignees, user(a))
+	}
+	if len(i.Assignees) > 0 {
+		issue.Assignee = user(i.Assignees[0])
+	}
+	if i.PullRequest != nil {
+		issue.PullRequestLinks = &github.PullRequestLinks{}
+	}
+	return issue
+}
+
+func (r *Repo) pullToGitHub(i *Issue) *github.PullRequest {
+	pr := i.PullRequest
+	pull := &github.PullRequest{
+		Number:    github.Int(i.Number),
+		Title:     github.String(i.Title),
+		Body:      github.String(i.Body),
+		State:     github.String(i.State),
+		User:      user(i.User),
+		CreatedAt: timestamp(i.CreatedAt),
+		UpdatedAt: timestamp(i.UpdatedAt),
+		ClosedAt:  timestamp(i.ClosedAt),
+		MergedAt:  timestamp(pr.MergedAt),
+		Merged:    github.Bool(pr.Merged),
+		Head:      &github.PullRequestBranch{Ref: github.String(pr.Head), Repo: r.toGitHub()},
+		Base:      &github.PullRequestBranch{Ref: github.String(pr.Base), Repo: r.toGitHub()},
+	}
+	if b := r.Branches[pr.Head]; b != nil {
+		pull.Head.SHA = github.String(b.head().SHA)
+	}
+	if b := r.Branches[pr.Base]; b != nil {
+		pull.Base.SHA = github.String(b.head().SHA)
+	}
+	if !pr.Merged {
+		pull.Commits = github.Int(len(r.ahead(pr.Base, pr.Head)))
+	}
+	return pull
+}
+
+func (c *Commit) toGitHub() *github.RepositoryCommit {
+	return &github.RepositoryCommit{
+		SHA:    github.String(c.SHA),
+		Author: user(c.Author),
+		Commit: &github.Commit{SHA: github.String(c.SHA), Message: github.String(c.Message)},
+	}
+}
+
+func getApp(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	reply(w, http.StatusOK, &github.App{ID: github.Int64(1), Name: github.String("git-training")})
+}
+
+func listInstallations(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	seen := map[int64]bool{}
+	installations := []*github.Installation{}
+	for _, name := range s.repoNames() {
+		repo := s.repos[name]
+		if !seen[repo.InstallationID] {
+			seen[repo.InstallationID] = true
+			installations = append(installations, &github.Installation{ID: github.Int64(repo.InstallationID), Account: user(repo.Owner)})
+		}
+	}
+	reply(w, http.StatusOK, installations)
+}
+
+// listInstallationRepos lists every repository, as every client the fake
+// hands out can see all of them.
+func listInstallationRepos(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repos := []*github.Repository{}
+	for _, name := range s.repoNames() {
+		repos = append(repos, s.repos[name].toGitHub())
+	}
+	reply(w, http.StatusOK, struct {
+		TotalCount   int                  `json:"total_count"`
+		Repositories []*github.Repository `json:"repositories"`
+	}{len(repos), repos})
+}
+
+func getRepo(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	if repo := s.repo(w, m); repo != nil {
+		reply(w, http.StatusOK, repo.toGitHub())
+	}
+}
+
+func listBranches(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+	branches := []*github.Branch{}
+	for _, name := range repo.branchNames() {
+		branches = append(branches, &github.Branch{Name: github.String(name), Commit: repo.Branches[name].head().toGitHub()})
+	}
+	reply(w, http.StatusOK, branches)
+}
+
+func getRef(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+	branch := repo.Branches[strings.TrimPrefix(m[3], "heads/")]
+	if !strings.HasPrefix(m[3], "heads/") || branch == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	reply(w, http.StatusOK, &github.Reference{
+		Ref:    github.String("refs/" + m[3]),
+		Object: &github.GitObject{Type: github.String("commit"), SHA: github.String(branch.head().SHA)},
+	})
+}
+
+// compareCommits compares branches or commit SHAs.
+func compareCommits(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+	if repo.history(m[3]) == nil || repo.history(m[4]) == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	comparison := &github.CommitsComparison{Commits: []github.RepositoryCommit{}, Files: repo.diff(m[3], m[4])}
+	for _, c := range repo.ahead(m[3], m[4]) {
+		comparison.Commits = append(comparison.Commits, *c.toGitHub())
+	}
+	comparison.AheadBy = github.Int(len(comparison.Commits))
+	comparison.BehindBy = github.Int(len(repo.ahead(m[4], m[3])))
+	comparison.TotalCommits = comparison.AheadBy
+	reply(w, http.StatusOK, comparison)
+}
+
+func getContents(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = repo.DefaultBranch
+	}
+	var commit *Commit
+	if commits := repo.history(ref); commits != nil {
+		commit = commits[len(commits)-1]
+	}
+	if commit == nil {
+		fail(w, http.StatusNotFound, "No commit found for the ref "+ref)
+		return
+	}
+
+	content, ok := commit.Files[m[3]]
+	if !ok {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	name := m[3][strings.LastIndex(m[3], "/")+1:]
+	reply(w, http.StatusOK, &github.RepositoryContent{
+		Type:     github.String("file"),
+		Name:     github.String(name),
+		Path:     github.String(m[3]),
+		Encoding: github.String("base64"),
+		Content:  github.String(base64.StdEncoding.EncodeToString([]byte(content))),
+		Size:     github.Int(len(content)),
+	})
+}
+
+// legacyPermissions maps roles to the permission levels GitHub reports
+// alongside them, which only go up to write for maintainers.
+var legacyPermissions = map[string]string{
+	"maintain": "write",
+	"triage":   "read",
+}
+
+// getPermissionLevel returns the role as well as the legacy permission.
+func getPermissionLevel(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+	role := repo.permission(m[3])
+	permission := role
+	if legacy, ok := legacyPermissions[role]; ok {
+		permission = legacy
+	}
+	reply(w, http.StatusOK, struct {
+		Permission string       `json:"permission"`
+		RoleName   string       `json:"role_name"`
+		User       *github.User `json:"user"`
+	}{permission, role, user(m[3])})
+}
+
+// listIssues supports the creator, labels and state filters, and pages.
+func listIssues(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+	if state == "" {
+		state = "open"
+	}
+
+	issues := []*github.Issue{}
+	for _, i := range repo.Issues {
+		if state != "all" && i.State != state {
+			continue
+		}
+		if creator := q.Get("creator"); creator != "" && i.User != creator {
+			continue
+		}
+		if labels := q.Get("labels"); labels != "" && !hasLabels(i, strings.Split(labels, ",")) {
+			continue
+		}
+		issues = append(issues, i.toGitHub())
+	}
+	if q.Get("direction") != "asc" {
+		for a, b := 0, len(issues)-1; a < b; a, b = a+1, b-1 {
+			issues[a], issues[b] = issues[b], issues[a]
+		}
+	}
+	start, end := page(w, r, len(issues))
+	reply(w, http.StatusOK, issues[start:end])
+}
+
+func hasLabels(i *Issue, labels []string) bool {
+	for _, want := range labels {
+		found := false
+		for _, l := range i.Labels {
+			if l == want {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func getIssue(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	if _, issue := s.issue(w, m); issue != nil {
+		reply(w, http.StatusOK, issue.toGitHub())
+	}
+}
+
+func listComments(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	_, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	since, _ := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+
+	comments := []*github.IssueComment{}
+	for _, c := range issue.Comments {
+		if c.CreatedAt.Before(since) {
+			continue
+		}
+		comments = append(comments, &github.IssueComment{
+			ID:        github.Int64(c.ID),
+			Body:      github.String(c.Body),
+			User:      user(c.User),
+			CreatedAt: timestamp(c.CreatedAt),
+		})
+	}
+	start, end := page(w, r, len(comments))
+	reply(w, http.StatusOK, comments[start:end])
+}
+
+// createComment comments as the app.
+func createComment(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	var req github.IssueComment
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.GetBody() == "" {
+		fail(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+
+	c := &Comment{ID: s.id(), User: BotLogin, Body: req.GetBody(), CreatedAt: s.now()}
+	issue.Comments = append(issue.Comments, c)
+	s.posts = append(s.posts, Post{Kind: "comment", Repo: repo.fullName(), Number: issue.Number, Body: c.Body})
+	reply(w, http.StatusCreated, &github.IssueComment{
+		ID:        github.Int64(c.ID),
+		Body:      github.String(c.Body),
+		User:      user(c.User),
+		CreatedAt: timestamp(c.CreatedAt),
+	})
+}
+
+func addLabels(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	_, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	var labels []string
+	if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+		fail(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+	for _, l := range labels {
+		if !hasLabels(issue, []string{l}) {
+			issue.Labels = append(issue.Labels, l)
+		}
+	}
+	reply(w, http.StatusOK, issue.toGitHub().Labels)
+}
+
+func listPulls(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo := s.repo(w, m)
+	if repo == nil {
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = "open"
+	}
+
+	pulls := []*github.PullRequest{}
+	for _, i := range repo.Issues {
+		if i.PullRequest == nil || state != "all" && i.State != state {
+			continue
+		}
+		pull := repo.pullToGitHub(i)
+		// Like the real API, the list leaves out the commit count.
+		pull.Commits = nil
+		pulls = append(pulls, pull)
+	}
+	reply(w, http.StatusOK, pulls)
+}
+
+func getPull(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	if issue.PullRequest == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	reply(w, http.StatusOK, repo.pullToGitHub(issue))
+}
+
+// listPullFiles lists the files a pull request changes, all on one page.
+func listPullFiles(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	if issue.PullRequest == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	reply(w, http.StatusOK, repo.diff(issue.PullRequest.Base, issue.PullRequest.Head))
+}
+
+func listReviews(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	_, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	reviews := []*github.PullRequestReview{}
+	for _, rv := range issue.Reviews {
+		reviews = append(reviews, rv.toGitHub())
+	}
+	start, end := page(w, r, len(reviews))
+	reply(w, http.StatusOK, reviews[start:end])
+}
+
+var reviewStates = map[string]string{
+	"APPROVE":         "APPROVED",
+	"REQUEST_CHANGES": "CHANGES_REQUESTED",
+	"COMMENT":         "COMMENTED",
+}
+
+// createReview reviews as the app. Like GitHub, it rejects review comments
+// on files the pull request doesn't change, or at positions outside their
+// diff.
+func createReview(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	repo, issue := s.issue(w, m)
+	if issue == nil {
+		return
+	}
+	if issue.PullRequest == nil {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	var req github.PullRequestReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || reviewStates[req.GetEvent()] == "" {
+		fail(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+
+	head := repo.Branches[issue.PullRequest.Head]
+	positions := map[string]int{}
+	for _, f := range repo.diff(issue.PullRequest.Base, issue.PullRequest.Head) {
+		positions[f.GetFilename()] = strings.Count(f.GetPatch(), "\n")
+	}
+	for _, c := range req.Comments {
+		n, ok := positions[c.GetPath()]
+		if !ok {
+			fail(w, http.StatusUnprocessableEntity, "Pull request review thread path is invalid")
+			return
+		}
+		if c.GetPosition() < 1 || c.GetPosition() > n {
+			fail(w, http.StatusUnprocessableEntity, "Pull request review thread position is invalid")
+			return
+		}
+	}
+
+	rv := &Review{
+		ID:          s.id(),
+		User:        BotLogin,
+		Body:        req.GetBody(),
+		State:       reviewStates[req.GetEvent()],
+		Comments:    req.Comments,
+		SubmittedAt: s.now(),
+	}
+	if head != nil {
+		rv.CommitID = head.head().SHA
+	}
+	issue.Reviews = append(issue.Reviews, rv)
+	s.posts = append(s.posts, Post{Kind: "review", Repo: repo.fullName(), Number: issue.Number, Event: req.GetEvent(), Body: rv.Body, Comments: rv.Comments})
+	reply(w, http.StatusOK, rv.toGitHub())
+}
+
+func (rv *Review) toGitHub() *github.PullRequestReview {
+	return &github.PullRequestReview{
+		ID:          github.Int64(rv.ID),
+		Body:        github.String(rv.Body),
+		User:        user(rv.User),
+		State:       github.String(rv.State),
+		CommitID:    github.String(rv.CommitID),
+		SubmittedAt: timestamp(rv.SubmittedAt),
+	}
+}
+
+func (s *Server) repoNames() []string {
+	var names []string
+	for name := range s.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// graphql answers the one GraphQL query the bot makes, for the issues a pull
+// request closes.
+func graphql(s *Server, w http.ResponseWriter, r *http.Request, m []string) {
+	var req struct {
+		Query     string `json:"query"`
+		Variables struct {
+			Owner  string `json:"owner"`
+			Repo   string `json:"repo"`
+			Number int    `json:"number"`
+		} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.Contains(req.Query, "closingIssuesReferences") {
+		reply(w, http.StatusOK, map[string]interface{}{"errors": []map[string]string{{"message": "unsupported query"}}})
+		return
+	}
+	repo := s.repos[req.Variables.Owner+"/"+req.Variables.Repo]
+	var issue *Issue
+	if repo != nil {
+		issue = repo.issue(req.Variables.Number)
+	}
+	if issue == nil || issue.PullRequest == nil {
+		reply(w, http.StatusOK, map[string]interface{}{"errors": []map[string]string{{"message": "Could not resolve to a PullRequest"}}})
+		return
+	}
+
+	nodes := []map[string]interface{}{}
+	for _, c := range issue.closing(s, repo) {
+		nodes = append(nodes, map[string]interface{}{
+			"number": c.issue.Number,
+			"repository": map[string]interface{}{
+				"owner": map[string]string{"login": c.repo.Owner},
+				"name":  c.repo.Name,
+			},
+		})
+	}
+	reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"repository": map[string]interface{}{
+			"pullRequest": map[string]interface{}{
+				"closingIssuesReferences": map[string]interface{}{"nodes": nodes},
+			},
+		},
+	}})
+}
@@ -0,0 +1,198 @@
+package githubtest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// Apply brings the server's state up to date with a webhook delivery that
+// was recorded from real GitHub, creating the repository, branches, commits,
+// issues and pull requests it mentions, so that the app sees the same world
+// when the delivery is replayed. File contents the payload doesn't carry are
+// made up, unless they were given to AddContents first.
+func (s *Server) Apply(eventType string, payload []byte) error {
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch event := event.(type) {
+	case *github.IssuesEvent:
+		repo := s.apply(event.GetInstallation().GetID(), event.GetRepo())
+		repo.applyIssue(event.GetIssue())
+	case *github.IssueCommentEvent:
+		repo := s.apply(event.GetInstallation().GetID(), event.GetRepo())
+		issue := repo.applyIssue(event.GetIssue())
+		if event.GetAction() == "created" {
+			c := event.GetComment()
+			issue.Comments = append(issue.Comments, &Comment{ID: c.GetID(), User: c.GetUser().GetLogin(), Body: c.GetBody(), CreatedAt: c.GetCreatedAt()})
+		}
+	case *github.CreateEvent:
+		repo := s.apply(event.GetInstallation().GetID(), event.GetRepo())
+		if event.GetRefType() == "branch" {
+			s.branch(repo, event.GetRef())
+		}
+	case *github.PushEvent:
+		r := event.GetRepo()
+		repo := s.apply(event.GetInstallation().GetID(), &github.Repository{
+			ID:            r.ID,
+			Name:          r.Name,
+			Owner:         &github.User{Login: github.String(r.GetOwner().GetName())},
+			DefaultBranch: r.DefaultBranch,
+		})
+		s.applyPush(repo, event)
+	case *github.PullRequestEvent:
+		repo := s.apply(event.GetInstallation().GetID(), event.GetRepo())
+		s.applyPull(repo, event.GetPullRequest())
+	}
+	return nil
+}
+
+// AddContents gives Apply the contents of files at the commits pushes will
+// mention, by commit and path.
+func (s *Server) AddContents(contents map[string]map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sha, files := range contents {
+		if s.contents[sha] == nil {
+			s.contents[sha] = map[string]string{}
+		}
+		for path, content := range files {
+			s.contents[sha][path] = content
+		}
+	}
+}
+
+// apply returns the repository, creating it if it's new.
+func (s *Server) apply(installationID int64, r *github.Repository) *Repo {
+	owner, name := r.GetOwner().GetLogin(), r.GetName()
+	repo := s.repos[owner+"/"+name]
+	if repo == nil {
+		branch := r.GetDefaultBranch()
+		if branch == "" {
+			branch = "master"
+		}
+		repo = &Repo{
+			ID:             r.GetID(),
+			InstallationID: installationID,
+			Owner:          owner,
+			Name:           name,
+			DefaultBranch:  branch,
+			Branches:       map[string]*Branch{},
+			Permissions:    map[string]string{owner: "admin"},
+		}
+		repo.Branches[branch] = &Branch{Commits: []*Commit{s.commit(nil, owner, "Initial commit", map[string]string{"README.md": "# " + name + "\n"})}}
+		s.repos[owner+"/"+name] = repo
+	}
+	return repo
+}
+
+// branch returns the branch, creating it from the default branch if it's
+// new.
+func (s *Server) branch(repo *Repo, name string) *Branch {
+	b := repo.Branches[name]
+	if b == nil {
+		base := repo.Branches[repo.DefaultBranch]
+		b = &Branch{Commits: append([]*Commit(nil), base.Commits...)}
+		repo.Branches[name] = b
+	}
+	return b
+}
+
+func (r *Repo) applyIssue(gi *github.Issue) *Issue {
+	issue := r.issue(gi.GetNumber())
+	if issue == nil {
+		issue = &Issue{Number: gi.GetNumber()}
+		r.Issues = append(r.Issues, issue)
+	}
+	issue.Title = gi.GetTitle()
+	issue.Body = gi.GetBody()
+	issue.User = gi.GetUser().GetLogin()
+	issue.State = gi.GetState()
+	issue.CreatedAt = gi.GetCreatedAt()
+	issue.UpdatedAt = gi.GetUpdatedAt()
+	issue.ClosedAt = gi.GetClosedAt()
+	issue.Labels = nil
+	for _, l := range gi.Labels {
+		issue.Labels = append(issue.Labels, l.GetName())
+	}
+	issue.Assignees = nil
+	for _, u := range gi.Assignees {
+		issue.Assignees = append(issue.Assignees, u.GetLogin())
+	}
+	if gi.IsPullRequest() && issue.PullRequest == nil {
+		issue.PullRequest = &PullRequest{}
+	}
+	return issue
+}
+
+func (s *Server) applyPush(repo *Repo, event *github.PushEvent) {
+	if !strings.HasPrefix(event.GetRef(), "refs/heads/") || event.GetDeleted() {
+		return
+	}
+	b := s.branch(repo, strings.TrimPrefix(event.GetRef(), "refs/heads/"))
+
+	known := map[string]bool{}
+	for _, c := range b.Commits {
+		known[c.SHA] = true
+	}
+	for _, pc := range event.Commits {
+		if known[pc.GetID()] {
+			continue
+		}
+		files := map[string]string{}
+		for path, content := range b.head().Files {
+			files[path] = content
+		}
+		for _, path := range append(append([]string(nil), pc.Added...), pc.Modified...) {
+			content, ok := s.contents[pc.GetID()][path]
+			if !ok {
+				content = fmt.Sprintf("Content of %s at %s\n", path, pc.GetID())
+			}
+			files[path] = content
+		}
+		for _, path := range pc.Removed {
+			delete(files, path)
+		}
+		author := pc.GetAuthor().GetLogin()
+		if author == "" {
+			author = event.GetSender().GetLogin()
+		}
+		b.Commits = append(b.Commits, &Commit{SHA: pc.GetID(), Author: author, Message: pc.GetMessage(), Files: files})
+		known[pc.GetID()] = true
+	}
+}
+
+func (s *Server) applyPull(repo *Repo, pr *github.PullRequest) {
+	issue := repo.issue(pr.GetNumber())
+	if issue == nil {
+		issue = &Issue{Number: pr.GetNumber()}
+		repo.Issues = append(repo.Issues, issue)
+	}
+	issue.Title = pr.GetTitle()
+	issue.Body = pr.GetBody()
+	issue.User = pr.GetUser().GetLogin()
+	issue.State = pr.GetState()
+	issue.CreatedAt = pr.GetCreatedAt()
+	issue.UpdatedAt = pr.GetUpdatedAt()
+	issue.ClosedAt = pr.GetClosedAt()
+	issue.PullRequest = &PullRequest{
+		Head:     pr.GetHead().GetRef(),
+		Base:     pr.GetBase().GetRef(),
+		Merged:   pr.GetMerged(),
+		MergedAt: pr.GetMergedAt(),
+	}
+
+	// Make up commits until the head is as far ahead as the payload says,
+	// in case the pushes that made them weren't recorded.
+	head := s.branch(repo, issue.PullRequest.Head)
+	s.branch(repo, issue.PullRequest.Base)
+	for n := len(repo.ahead(issue.PullRequest.Base, issue.PullRequest.Head)); n < pr.GetCommits(); n++ {
+		head.Commits = append(head.Commits, s.commit(head.head(), issue.User, "Unrecorded commit", nil))
+	}
+}
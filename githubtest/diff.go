@@ -0,0 +1,183 @@
+package githubtest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diff lists the files that differ between the base and the head, which are
+// branches or commit SHAs, with their patches, as the compare and pull
+// request files APIs do.
+func (r *Repo) diff(base, head string) []github.CommitFile {
+	b, h := r.history(base), r.history(head)
+	if b == nil || h == nil {
+		return nil
+	}
+	before, after := b[len(b)-1].Files, h[len(h)-1].Files
+
+	var paths []string
+	for path := range r.changed(base, head) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	files := []github.CommitFile{}
+	for _, path := range paths {
+		old, inBase := before[path]
+		new, inHead := after[path]
+		status := "modified"
+		if !inBase {
+			status = "added"
+		} else if !inHead {
+			status = "removed"
+		}
+		p := patch(old, new)
+		files = append(files, github.CommitFile{
+			Filename:  github.String(path),
+			Status:    github.String(status),
+			Additions: github.Int(strings.Count("\n"+p, "\n+")),
+			Deletions: github.Int(strings.Count("\n"+p, "\n-")),
+			Patch:     github.String(p),
+		})
+	}
+	return files
+}
+
+// diffLine is a line of a diff: ' ', '-' or '+' and the text, with its line
+// number in each version, or the number of lines before it in a version it
+// isn't in.
+type diffLine struct {
+	kind     byte
+	text     string
+	old, new int
+}
+
+// patch returns the unified diff, without the file headers, from one version
+// of a file to another, as GitHub gives it for a file in a pull request.
+func patch(before, after string) string {
+	a, b := lines(before), lines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []diffLine
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, diffLine{' ', a[i], i + 1, j + 1})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, diffLine{'-', a[i], i + 1, j})
+			i++
+		default:
+			diff = append(diff, diffLine{'+', b[j], i, j + 1})
+			j++
+		}
+	}
+
+	var out []string
+	for _, hunk := range hunks(diff) {
+		oldStart, newStart := hunk[0].old, hunk[0].new
+		if hunk[0].kind == '+' {
+			oldStart++
+		}
+		if hunk[0].kind == '-' {
+			newStart++
+		}
+		oldCount, newCount := 0, 0
+		for _, l := range hunk {
+			if l.kind != '+' {
+				oldCount++
+			}
+			if l.kind != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		out = append(out, fmt.Sprintf("@@ -%s +%s @@", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount)))
+		for _, l := range hunk {
+			out = append(out, string(l.kind)+l.text)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// hunks groups the changes in a diff with the unchanged lines around them,
+// joining changes close enough to share their context.
+func hunks(diff []diffLine) [][]diffLine {
+	var hunks [][]diffLine
+	for k := 0; k < len(diff); {
+		if diff[k].kind == ' ' {
+			k++
+			continue
+		}
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(diff) {
+			if diff[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(diff) && diff[run].kind == ' ' {
+				run++
+			}
+			if run == len(diff) || run-end > 2*diffContext {
+				end += diffContext
+				if end > len(diff) {
+					end = len(diff)
+				}
+				break
+			}
+			end = run
+		}
+		hunks = append(hunks, diff[start:end])
+		k = end
+	}
+	return hunks
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,389 @@
+// Package githubtest is an in-memory fake of the parts of the GitHub API the
+// bot uses, with a simulator that plays a trainee's side of a course against
+// it, for tests that run without a network.
+package githubtest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/shurcooL/githubv4"
+)
+
+// BotLogin is the login the fake gives the app's own comments and reviews.
+const BotLogin = "git-training[bot]"
+
+// Server is a fake GitHub API. Its state is changed through the Simulator
+// or the exported methods, and read back through Posts.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	clock   time.Time
+	stopped bool
+	ids     int64
+	repos   map[string]*Repo
+	posts   []Post
+	// contents are file contents for Apply, by commit and path.
+	contents map[string]map[string]string
+}
+
+// Repo is a repository on the fake server. Issues and pull requests share
+// one sequence of numbers.
+type Repo struct {
+	ID             int64
+	InstallationID int64
+	Owner          string
+	Name           string
+	DefaultBranch  string
+	Branches       map[string]*Branch
+	Issues         []*Issue
+	// Permissions maps logins to their permission on the repository. Users
+	// not listed have read permission, except the owner, who is an admin.
+	Permissions map[string]string
+}
+
+// Branch is a branch's history, oldest commit first.
+type Branch struct {
+	Commits []*Commit
+}
+
+func (b *Branch) head() *Commit {
+	return b.Commits[len(b.Commits)-1]
+}
+
+// Commit is a snapshot of every file in the repository.
+type Commit struct {
+	SHA     string
+	Author  string
+	Message string
+	Files   map[string]string
+}
+
+// Issue is an issue, or a pull request when PullRequest is set.
+type Issue struct {
+	Number      int
+	Title       string
+	Body        string
+	User        string
+	State       string
+	Labels      []string
+	Assignees   []string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ClosedAt    time.Time
+	PullRequest *PullRequest
+	Comments    []*Comment
+	Reviews     []*Review
+}
+
+type PullRequest struct {
+	Head     string
+	Base     string
+	Merged   bool
+	MergedAt time.Time
+	// Linked are the issues linked to the pull request from its sidebar.
+	Linked []int
+}
+
+type Comment struct {
+	ID        int64
+	User      string
+	Body      string
+	CreatedAt time.Time
+}
+
+type Review struct {
+	ID          int64
+	User        string
+	Body        string
+	State       string
+	CommitID    string
+	Comments    []*github.DraftReviewComment
+	SubmittedAt time.Time
+}
+
+// Post is a comment or review made by the app, in the order it was made.
+type Post struct {
+	Kind     string // "comment" or "review"
+	Repo     string
+	Number   int
+	Event    string // the review event
+	Body     string
+	Comments []*github.DraftReviewComment
+}
+
+// NewServer starts a fake GitHub API. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		clock:    time.Now().UTC().Truncate(time.Second),
+		repos:    map[string]*Repo{},
+		contents: map[string]map[string]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// now advances the fake clock by a second, so that every change has its own
+// time, unless the clock is stopped.
+func (s *Server) now() time.Time {
+	if !s.stopped {
+		s.clock = s.clock.Add(time.Second)
+	}
+	return s.clock
+}
+
+// StopClock stops the fake clock, so that every later change happens in the
+// same second, as quick ones can on GitHub.
+func (s *Server) StopClock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+}
+
+func (s *Server) id() int64 {
+	s.ids++
+	return s.ids
+}
+
+// AddRepo creates a repository with one commit on its default branch,
+// "master", and installs the app on it.
+func (s *Server) AddRepo(installationID int64, owner, name string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &Repo{
+		ID:             s.id(),
+		InstallationID: installationID,
+		Owner:          owner,
+		Name:           name,
+		DefaultBranch:  "master",
+		Branches:       map[string]*Branch{},
+		Permissions:    map[string]string{owner: "admin"},
+	}
+	r.Branches["master"] = &Branch{Commits: []*Commit{s.commit(nil, owner, "Initial commit", map[string]string{"README.md": "# " + name + "\n"})}}
+	s.repos[owner+"/"+name] = r
+	return r
+}
+
+// Repo returns the repository with the given full name.
+func (s *Server) Repo(fullName string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repos[fullName]
+}
+
+// SetPermission gives the user a role, such as "admin", "maintain" or
+// "write", on the repository.
+func (s *Server) SetPermission(fullName, login, permission string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repos[fullName].Permissions[login] = permission
+}
+
+// RenameBranch renames a branch, as the repository's branch settings do. Open
+// pull requests based on it are retargeted, and renaming the default branch
+// changes the repository's default.
+func (s *Server) RenameBranch(fullName, from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.repos[fullName]
+	r.Branches[to] = r.Branches[from]
+	delete(r.Branches, from)
+	if r.DefaultBranch == from {
+		r.DefaultBranch = to
+	}
+	for _, issue := range r.Issues {
+		if pr := issue.PullRequest; pr != nil && issue.State == "open" {
+			if pr.Base == from {
+				pr.Base = to
+			}
+			if pr.Head == from {
+				pr.Head = to
+			}
+		}
+	}
+}
+
+// Posts returns the comments and reviews the app has made, oldest first.
+func (s *Server) Posts() []Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Post(nil), s.posts...)
+}
+
+func (s *Server) commit(parent *Commit, author, message string, changes map[string]string) *Commit {
+	files := map[string]string{}
+	if parent != nil {
+		for path, content := range parent.Files {
+			files[path] = content
+		}
+	}
+	for path, content := range changes {
+		files[path] = content
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%d %s %s", s.id(), author, message)
+	return &Commit{SHA: hex.EncodeToString(h.Sum(nil)), Author: author, Message: message, Files: files}
+}
+
+func (r *Repo) issue(number int) *Issue {
+	for _, issue := range r.Issues {
+		if issue.Number == number {
+			return issue
+		}
+	}
+	return nil
+}
+
+// nextNumber returns the number the next issue or pull request gets.
+func (r *Repo) nextNumber() int {
+	n := 1
+	for _, issue := range r.Issues {
+		if issue.Number >= n {
+			n = issue.Number + 1
+		}
+	}
+	return n
+}
+
+func (r *Repo) fullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+func (r *Repo) permission(login string) string {
+	if p, ok := r.Permissions[login]; ok {
+		return p
+	}
+	return "read"
+}
+
+// history returns the commits up to the one the ref names, oldest first.
+// The ref is a branch or a commit SHA; history is nil if there's no such
+// ref.
+func (r *Repo) history(ref string) []*Commit {
+	if b := r.Branches[strings.TrimPrefix(ref, "refs/heads/")]; b != nil {
+		return b.Commits
+	}
+	for _, name := range r.branchNames() {
+		commits := r.Branches[name].Commits
+		for i, c := range commits {
+			if c.SHA == ref {
+				return commits[:i+1]
+			}
+		}
+	}
+	return nil
+}
+
+// ahead returns the commits on the head that aren't on the base, which are
+// branches or commit SHAs.
+func (r *Repo) ahead(base, head string) []*Commit {
+	onBase := map[string]bool{}
+	for _, c := range r.history(base) {
+		onBase[c.SHA] = true
+	}
+	var commits []*Commit
+	for _, c := range r.history(head) {
+		if !onBase[c.SHA] {
+			commits = append(commits, c)
+		}
+	}
+	return commits
+}
+
+// changed returns the paths whose content differs between the base and the
+// head, which are branches or commit SHAs.
+func (r *Repo) changed(base, head string) map[string]bool {
+	paths := map[string]bool{}
+	b, h := r.history(base), r.history(head)
+	if b == nil || h == nil {
+		return paths
+	}
+	before, after := b[len(b)-1].Files, h[len(h)-1].Files
+	for path, content := range after {
+		if before[path] != content {
+			paths[path] = true
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			paths[path] = true
+		}
+	}
+	return paths
+}
+
+func (r *Repo) branchNames() []string {
+	var names []string
+	for name := range r.Branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClientCreator returns a githubapp.ClientCreator whose clients all talk to
+// the fake server through the given middleware.
+func (s *Server) ClientCreator(middleware ...githubapp.ClientMiddleware) githubapp.ClientCreator {
+	return clientCreator{s, middleware}
+}
+
+type clientCreator struct {
+	s          *Server
+	middleware []githubapp.ClientMiddleware
+}
+
+func (c clientCreator) httpClient() *http.Client {
+	transport := http.DefaultTransport
+	for _, m := range c.middleware {
+		transport = m(transport)
+	}
+	return &http.Client{Transport: transport}
+}
+
+func (c clientCreator) client() *github.Client {
+	client := github.NewClient(c.httpClient())
+	client.BaseURL, _ = url.Parse(c.s.URL + "/")
+	return client
+}
+
+func (c clientCreator) v4Client() *githubv4.Client {
+	return githubv4.NewEnterpriseClient(c.s.URL+"/graphql", c.httpClient())
+}
+
+func (c clientCreator) NewAppClient() (*github.Client, error) {
+	return c.client(), nil
+}
+
+func (c clientCreator) NewAppV4Client() (*githubv4.Client, error) {
+	return c.v4Client(), nil
+}
+
+func (c clientCreator) NewInstallationClient(installationID int64) (*github.Client, error) {
+	return c.client(), nil
+}
+
+func (c clientCreator) NewInstallationV4Client(installationID int64) (*githubv4.Client, error) {
+	return c.v4Client(), nil
+}
+
+func (c clientCreator) NewTokenClient(token string) (*github.Client, error) {
+	return c.client(), nil
+}
+
+func (c clientCreator) NewTokenV4Client(token string) (*githubv4.Client, error) {
+	return c.v4Client(), nil
+}
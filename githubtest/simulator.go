@@ -0,0 +1,486 @@
+package githubtest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// Simulator plays trainees' actions against the fake server, and sends the
+// webhooks GitHub would send for them, signed with the secret, to the app's
+// handler.
+type Simulator struct {
+	Server  *Server
+	Handler http.Handler
+	Secret  string
+	// Settle, if set, is called after every delivery to wait for the app to
+	// finish handling it.
+	Settle func()
+
+	t          testing.TB
+	deliveries int
+	last       *http.Request
+	lastBody   []byte
+}
+
+func NewSimulator(t testing.TB, s *Server, handler http.Handler, secret string) *Simulator {
+	return &Simulator{Server: s, Handler: handler, Secret: secret, t: t}
+}
+
+// Trainee returns a trainee working in the repository, which must exist.
+func (sim *Simulator) Trainee(fullName, login string) *Trainee {
+	repo := sim.Server.Repo(fullName)
+	if repo == nil {
+		sim.t.Fatalf("no repository %s", fullName)
+	}
+	return &Trainee{sim: sim, repo: repo, Login: login}
+}
+
+// Deliver sends a webhook to the app's handler and waits for it to settle.
+func (sim *Simulator) Deliver(eventType string, payload interface{}) {
+	sim.t.Helper()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		sim.t.Fatalf("failed to encode %s payload: %s", eventType, err)
+	}
+	sim.deliveries++
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", eventType)
+	req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("00000000-0000-0000-0000-%012d", sim.deliveries))
+	req.Header.Set("X-Hub-Signature", Sign(sim.Secret, body))
+	sim.last, sim.lastBody = req, body
+	sim.send(req, body)
+}
+
+// Sign returns the X-Hub-Signature header GitHub sends with the payload.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Retry sends the last delivery again with the same delivery ID, as GitHub
+// does when it retries a delivery.
+func (sim *Simulator) Retry() {
+	sim.t.Helper()
+	sim.send(sim.last, sim.lastBody)
+}
+
+// Redeliver sends the last delivery's payload again with a new delivery ID.
+func (sim *Simulator) Redeliver() {
+	sim.t.Helper()
+	sim.deliveries++
+	req := sim.last.Clone(sim.last.Context())
+	req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("00000000-0000-0000-0000-%012d", sim.deliveries))
+	sim.send(req, sim.lastBody)
+}
+
+func (sim *Simulator) send(req *http.Request, body []byte) {
+	sim.t.Helper()
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	sim.Handler.ServeHTTP(w, req)
+	if w.Code >= 300 {
+		sim.t.Fatalf("%s delivery failed with %d: %s", req.Header.Get("X-GitHub-Event"), w.Code, w.Body.String())
+	}
+	if sim.Settle != nil {
+		sim.Settle()
+	}
+}
+
+// Trainee is a user taking a course in a repository on the fake server.
+type Trainee struct {
+	Login string
+
+	sim  *Simulator
+	repo *Repo
+}
+
+func (t *Trainee) lock() func() {
+	t.sim.Server.mu.Lock()
+	return t.sim.Server.mu.Unlock
+}
+
+func (t *Trainee) sender() *github.User {
+	return user(t.Login)
+}
+
+func (t *Trainee) installation() *github.Installation {
+	return &github.Installation{ID: github.Int64(t.repo.InstallationID)}
+}
+
+func (t *Trainee) issue(number int) *Issue {
+	issue := t.repo.issue(number)
+	if issue == nil {
+		t.sim.t.Fatalf("no issue #%d in %s", number, t.repo.fullName())
+	}
+	return issue
+}
+
+// OpenIssue opens an issue and returns its number.
+func (t *Trainee) OpenIssue(title, body string) int {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	now := t.sim.Server.now()
+	issue := &Issue{
+		Number:    t.repo.nextNumber(),
+		Title:     title,
+		Body:      body,
+		User:      t.Login,
+		State:     "open",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	t.repo.Issues = append(t.repo.Issues, issue)
+	payload := t.issuesEvent("opened", issue)
+	unlock()
+
+	t.sim.Deliver("issues", payload)
+	return issue.Number
+}
+
+// Assign assigns the user to the issue.
+func (t *Trainee) Assign(number int, login string) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	issue.Assignees = append(issue.Assignees, login)
+	issue.UpdatedAt = t.sim.Server.now()
+	payload := t.issuesEvent("assigned", issue)
+	payload.Assignee = user(login)
+	unlock()
+
+	t.sim.Deliver("issues", payload)
+}
+
+// AddLabel labels the issue.
+func (t *Trainee) AddLabel(number int, name string) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	issue.Labels = append(issue.Labels, name)
+	issue.UpdatedAt = t.sim.Server.now()
+	payload := t.issuesEvent("labeled", issue)
+	payload.Label = &github.Label{Name: github.String(name)}
+	unlock()
+
+	t.sim.Deliver("issues", payload)
+}
+
+// CloseIssue closes the issue.
+func (t *Trainee) CloseIssue(number int) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	t.close(issue)
+	payload := t.issuesEvent("closed", issue)
+	unlock()
+
+	t.sim.Deliver("issues", payload)
+}
+
+func (t *Trainee) close(issue *Issue) {
+	issue.State = "closed"
+	issue.ClosedAt = t.sim.Server.now()
+	issue.UpdatedAt = issue.ClosedAt
+}
+
+func (t *Trainee) issuesEvent(action string, issue *Issue) *github.IssuesEvent {
+	return &github.IssuesEvent{
+		Action:       github.String(action),
+		Issue:        issue.toGitHub(),
+		Repo:         t.repo.toGitHub(),
+		Sender:       t.sender(),
+		Installation: t.installation(),
+	}
+}
+
+// Comment comments on an issue or pull request.
+func (t *Trainee) Comment(number int, body string) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	c := &Comment{ID: t.sim.Server.id(), User: t.Login, Body: body, CreatedAt: t.sim.Server.now()}
+	issue.Comments = append(issue.Comments, c)
+	payload := &github.IssueCommentEvent{
+		Action: github.String("created"),
+		Issue:  issue.toGitHub(),
+		Comment: &github.IssueComment{
+			ID:        github.Int64(c.ID),
+			Body:      github.String(c.Body),
+			User:      t.sender(),
+			CreatedAt: timestamp(c.CreatedAt),
+		},
+		Repo:         t.repo.toGitHub(),
+		Sender:       t.sender(),
+		Installation: t.installation(),
+	}
+	unlock()
+
+	t.sim.Deliver("issue_comment", payload)
+}
+
+// CreateBranch creates a branch from the default branch, as the branch
+// drop-down on the Code tab does.
+func (t *Trainee) CreateBranch(name string) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	if t.repo.Branches[name] != nil {
+		t.sim.t.Fatalf("branch %s already exists", name)
+	}
+	base := t.repo.Branches[t.repo.DefaultBranch]
+	t.repo.Branches[name] = &Branch{Commits: append([]*Commit(nil), base.Commits...)}
+	create := &github.CreateEvent{
+		Ref:          github.String(name),
+		RefType:      github.String("branch"),
+		MasterBranch: github.String(t.repo.DefaultBranch),
+		PusherType:   github.String("user"),
+		Repo:         t.repo.toGitHub(),
+		Sender:       t.sender(),
+		Installation: t.installation(),
+	}
+	push := t.pushEvent(name, base.head().SHA, nil)
+	push.Created = github.Bool(true)
+	unlock()
+
+	t.sim.Deliver("create", create)
+	t.sim.Deliver("push", push)
+}
+
+// Commit commits a file to a branch, as the web editor does. When the branch
+// is the head of an open pull request, the pull request is synchronized.
+func (t *Trainee) Commit(branch, path, content, message string) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	b := t.repo.Branches[branch]
+	if b == nil {
+		t.sim.t.Fatalf("no branch %s", branch)
+	}
+	before := b.head()
+	c := t.sim.Server.commit(before, t.Login, message, map[string]string{path: content})
+	b.Commits = append(b.Commits, c)
+	push := t.pushEvent(branch, before.SHA, []*Commit{c})
+	if _, ok := before.Files[path]; ok {
+		push.Commits[0].Modified = []string{path}
+	} else {
+		push.Commits[0].Added = []string{path}
+	}
+	push.HeadCommit = &push.Commits[0]
+
+	var syncs []*github.PullRequestEvent
+	for _, issue := range t.repo.Issues {
+		if pr := issue.PullRequest; pr != nil && pr.Head == branch && issue.State == "open" {
+			issue.UpdatedAt = t.sim.Server.now()
+			syncs = append(syncs, t.pullRequestEvent("synchronize", issue))
+		}
+	}
+	unlock()
+
+	t.sim.Deliver("push", push)
+	for _, payload := range syncs {
+		t.sim.Deliver("pull_request", payload)
+	}
+}
+
+func (t *Trainee) pushEvent(branch, before string, commits []*Commit) *github.PushEvent {
+	after := t.repo.Branches[branch].head().SHA
+	push := &github.PushEvent{
+		Ref:     github.String("refs/heads/" + branch),
+		Before:  github.String(before),
+		After:   github.String(after),
+		Created: github.Bool(false),
+		Deleted: github.Bool(false),
+		Repo: &github.PushEventRepository{
+			ID:            github.Int64(t.repo.ID),
+			Name:          github.String(t.repo.Name),
+			FullName:      github.String(t.repo.fullName()),
+			Owner:         &github.PushEventRepoOwner{Name: github.String(t.repo.Owner)},
+			DefaultBranch: github.String(t.repo.DefaultBranch),
+		},
+		Pusher:       t.sender(),
+		Sender:       t.sender(),
+		Installation: t.installation(),
+	}
+	for _, c := range commits {
+		push.Commits = append(push.Commits, github.PushEventCommit{
+			ID:       github.String(c.SHA),
+			Message:  github.String(c.Message),
+			Author:   &github.CommitAuthor{Name: github.String(c.Author), Login: github.String(c.Author)},
+			Distinct: github.Bool(true),
+		})
+	}
+	return push
+}
+
+// OpenPullRequest opens a pull request from the branch into the default
+// branch and returns its number.
+func (t *Trainee) OpenPullRequest(head, title, body string) int {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	if t.repo.Branches[head] == nil {
+		t.sim.t.Fatalf("no branch %s", head)
+	}
+	now := t.sim.Server.now()
+	issue := &Issue{
+		Number:      t.repo.nextNumber(),
+		Title:       title,
+		Body:        body,
+		User:        t.Login,
+		State:       "open",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		PullRequest: &PullRequest{Head: head, Base: t.repo.DefaultBranch},
+	}
+	t.repo.Issues = append(t.repo.Issues, issue)
+	payload := t.pullRequestEvent("opened", issue)
+	unlock()
+
+	t.sim.Deliver("pull_request", payload)
+	return issue.Number
+}
+
+// EditPullRequest replaces the description of the pull request.
+func (t *Trainee) EditPullRequest(number int, body string) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	from := issue.Body
+	issue.Body = body
+	issue.UpdatedAt = t.sim.Server.now()
+	payload := t.pullRequestEvent("edited", issue)
+	payload.Changes = &github.EditChange{Body: &struct {
+		From *string `json:"from,omitempty"`
+	}{From: github.String(from)}}
+	unlock()
+
+	t.sim.Deliver("pull_request", payload)
+}
+
+// LinkIssue links the issue to the pull request from the pull request's
+// sidebar, which GitHub doesn't send a webhook for.
+func (t *Trainee) LinkIssue(number, issue int) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	defer unlock()
+	pr := t.issue(number).PullRequest
+	pr.Linked = append(pr.Linked, issue)
+}
+
+var closingKeyword = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)(?::\s*|\s+)([\w.-]+/[\w.-]+)?#(\d+)`)
+
+// closedIssue is an issue a pull request closes, and its repository.
+type closedIssue struct {
+	repo  *Repo
+	issue *Issue
+}
+
+// closing returns the issues merging the pull request in r closes: those its
+// description links with a closing keyword, in r or, as owner/repo#N, in
+// another repository on s, and those linked from its sidebar.
+func (issue *Issue) closing(s *Server, r *Repo) []closedIssue {
+	var closed []closedIssue
+	add := func(repo *Repo, n int) {
+		if repo == nil {
+			return
+		}
+		if i := repo.issue(n); i != nil && i.PullRequest == nil {
+			closed = append(closed, closedIssue{repo, i})
+		}
+	}
+	for _, n := range issue.PullRequest.Linked {
+		add(r, n)
+	}
+	for _, m := range closingKeyword.FindAllStringSubmatch(issue.Body, -1) {
+		n, _ := strconv.Atoi(m[2])
+		if m[1] == "" {
+			add(r, n)
+		} else {
+			add(s.repos[m[1]], n)
+		}
+	}
+	return closed
+}
+
+// MergePullRequest merges the pull request, closing the issues it links.
+func (t *Trainee) MergePullRequest(number int) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	pr := issue.PullRequest
+	base := t.repo.Branches[pr.Base]
+	before := base.head().SHA
+	merged := t.repo.ahead(pr.Base, pr.Head)
+	base.Commits = append(base.Commits, merged...)
+
+	t.close(issue)
+	pr.Merged = true
+	pr.MergedAt = issue.ClosedAt
+	closed := t.pullRequestEvent("closed", issue)
+	push := t.pushEvent(pr.Base, before, merged)
+
+	var resolved []*github.IssuesEvent
+	for _, c := range issue.closing(t.sim.Server, t.repo) {
+		// Issues in other repositories are left alone.
+		if c.repo == t.repo && c.issue.State == "open" {
+			t.close(c.issue)
+			resolved = append(resolved, t.issuesEvent("closed", c.issue))
+		}
+	}
+	unlock()
+
+	t.sim.Deliver("pull_request", closed)
+	t.sim.Deliver("push", push)
+	for _, payload := range resolved {
+		t.sim.Deliver("issues", payload)
+	}
+}
+
+// ClosePullRequest closes the pull request without merging it.
+func (t *Trainee) ClosePullRequest(number int) {
+	t.sim.t.Helper()
+
+	unlock := t.lock()
+	issue := t.issue(number)
+	t.close(issue)
+	payload := t.pullRequestEvent("closed", issue)
+	unlock()
+
+	t.sim.Deliver("pull_request", payload)
+}
+
+func (t *Trainee) pullRequestEvent(action string, issue *Issue) *github.PullRequestEvent {
+	return &github.PullRequestEvent{
+		Action:       github.String(action),
+		Number:       github.Int(issue.Number),
+		PullRequest:  t.repo.pullToGitHub(issue),
+		Repo:         t.repo.toGitHub(),
+		Sender:       t.sender(),
+		Installation: t.installation(),
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/fanatic/git-training
 
-go 1.12
+go 1.16
 
 require (
 	github.com/bluekeyes/hatpear v0.0.0-20180714193905-ffb42d5bb417 // indirect
@@ -17,9 +17,11 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/rcrowley/go-metrics v0.0.0-20181016184325-3113b8401b8a // indirect
 	github.com/rs/zerolog v1.14.3
-	github.com/shurcooL/githubv4 v0.0.0-20190601194912-068505affed7 // indirect
+	github.com/shurcooL/githubv4 v0.0.0-20190601194912-068505affed7
 	github.com/shurcooL/graphql v0.0.0-20181231061246-d48a9a75455f // indirect
 	github.com/sirupsen/logrus v1.4.2
+	go.etcd.io/bbolt v1.3.5
 	goji.io v2.0.2+incompatible // indirect
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fanatic/git-training/githubtest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// transcript interleaves a scenario's steps with everything the bot posted
+// in response to each of them.
+type transcript struct {
+	gh   *githubtest.Server
+	buf  bytes.Buffer
+	seen int
+}
+
+func (tr *transcript) step(description string, fn func()) {
+	fn()
+	fmt.Fprintf(&tr.buf, "> %s\n\n", description)
+
+	posts := tr.gh.Posts()
+	writePosts(&tr.buf, posts[tr.seen:])
+	tr.seen = len(posts)
+}
+
+var scenarios = []struct {
+	name string
+	run  func(tr *transcript, octocat *githubtest.Trainee)
+}{
+	{"happy_path", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat adds \"Resolves #1\" to #2", func() { octocat.EditPullRequest(2, "Resolves #1") })
+		tr.step("octocat replaces line 1 of users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Talk is cheap. Show me the code.\n", "Update octocat.md")
+		})
+		tr.step("octocat merges #2", func() { octocat.MergePullRequest(2) })
+	}},
+	{"wrong_branch_name", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch patch-1 instead of feat/octocat-1", func() { octocat.CreateBranch("patch-1") })
+		tr.step("octocat creates the reserved branch develop", func() { octocat.CreateBranch("develop") })
+		tr.step("octocat creates branch Feat/Octocat-1", func() { octocat.CreateBranch("Feat/Octocat-1") })
+		tr.step("octocat commits users/octocat.md to Feat/Octocat-1", func() {
+			octocat.Commit("Feat/Octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+	}},
+	{"forgot_resolves_link", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat edits #2 without linking #1", func() { octocat.EditPullRequest(2, "Adds my file") })
+		tr.step("octocat replaces line 1 of users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Talk is cheap. Show me the code.\n", "Update octocat.md")
+		})
+		tr.step("octocat asks for a hint on #2", func() { octocat.Comment(2, "/hint") })
+		tr.step("octocat adds \"Resolves #1\" to #2", func() { octocat.EditPullRequest(2, "Adds my file\n\nResolves #1") })
+	}},
+	{"issue_link_variants", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat mentions #1 without a closing keyword", func() { octocat.EditPullRequest(2, "Adds my file for #1.") })
+		tr.step("octocat links issue 1 of another repository", func() {
+			octocat.EditPullRequest(2, "Closes https://github.com/training/other/issues/1")
+		})
+		tr.step("octocat adds \"FIXES: training/hello-world#1\" to #2", func() {
+			octocat.EditPullRequest(2, "Adds my file\n\nFIXES: training/hello-world#1")
+		})
+	}},
+	{"linked_from_sidebar", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat links #1 from the sidebar of #2", func() { octocat.LinkIssue(2, 1) })
+		tr.step("octocat edits #2 without mentioning #1", func() { octocat.EditPullRequest(2, "Adds my file") })
+	}},
+	{"closed_without_merge", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat adds \"Resolves #1\" to #2", func() { octocat.EditPullRequest(2, "Resolves #1") })
+		tr.step("octocat replaces line 1 of users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Talk is cheap. Show me the code.\n", "Update octocat.md")
+		})
+		tr.step("octocat closes #2 without merging", func() { octocat.ClosePullRequest(2) })
+	}},
+	{"renamed_default_branch", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("the default branch is renamed from master to main", func() { tr.gh.RenameBranch(testRepo, "master", "main") })
+		tr.step("octocat asks for two hints on #1", func() {
+			octocat.Comment(1, "/hint")
+			octocat.Comment(1, "/hint")
+		})
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md to main by mistake", func() {
+			octocat.Commit("main", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat commits users/octocat.md to feat/octocat-1", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+	}},
+	{"wrong_file", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits octocat.md outside the users directory", func() {
+			octocat.Commit("feat/octocat-1", "octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat commits users/octocat.md with the wrong text", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello world\n", "Create octocat.md")
+		})
+		tr.step("octocat fixes the text of users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Update octocat.md")
+		})
+	}},
+	{"unreplaced_line", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat adds \"Resolves #1\" to #2", func() { octocat.EditPullRequest(2, "Resolves #1") })
+		tr.step("octocat commits another file instead", func() {
+			octocat.Commit("feat/octocat-1", "users/notes.md", "Talk is cheap.\n", "Add notes")
+		})
+		tr.step("octocat adds a second line but keeps line 1", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\nTalk is cheap.\n", "Update octocat.md")
+		})
+		tr.step("octocat replaces line 1 of users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Talk is cheap. Show me the code.\n", "Update octocat.md")
+		})
+	}},
+	{"file_on_default_branch", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat commits users/octocat.md to master", func() {
+			octocat.Commit("master", "users/octocat.md", "Talk is cheap.\nShow me the code.\n", "Create octocat.md")
+		})
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat replaces users/octocat.md", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Update octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat adds \"Resolves #1\" to #2", func() { octocat.EditPullRequest(2, "Resolves #1") })
+	}},
+	{"file_not_in_diff", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat commits users/octocat.md to master", func() {
+			octocat.Commit("master", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+		})
+		tr.step("octocat opens issue #1", func() { octocat.OpenIssue("Hello, my name is Octocat!", "") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat creates branch feat/octocat-1", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat commits users/octocat.md unchanged", func() {
+			octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Update octocat.md")
+		})
+		tr.step("octocat opens pull request #2", func() { octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "") })
+		tr.step("octocat adds \"Resolves #1\" to #2", func() { octocat.EditPullRequest(2, "Resolves #1") })
+	}},
+	{"german_trainee", func(tr *transcript, octocat *githubtest.Trainee) {
+		tr.step("octocat opens issue #1 asking for German", func() { octocat.OpenIssue("Hallo, ich bin Octocat!", "Language: de") })
+		tr.step("octocat creates branch feat/octocat-1 too early", func() { octocat.CreateBranch("feat/octocat-1") })
+		tr.step("octocat assigns themselves to #1", func() { octocat.Assign(1, "octocat") })
+		tr.step("octocat asks for a hint on #1", func() { octocat.Comment(1, "/hint") })
+		tr.step("octocat asks to switch to Klingon", func() { octocat.Comment(1, "/lang tlh") })
+		tr.step("octocat switches to English", func() { octocat.Comment(1, "/lang en") })
+		tr.step("octocat asks for another hint on #1", func() { octocat.Comment(1, "/hint") })
+	}},
+}
+
+// TestGolden runs each scenario and compares the transcript with
+// testdata/<scenario>.golden. Run with -update to accept changes.
+func TestGolden(t *testing.T) {
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			gh, sim := newTestApp(t)
+			defer gh.Close()
+
+			tr := &transcript{gh: gh}
+			sc.run(tr, sim.Trainee(testRepo, "octocat"))
+			got := tr.buf.Bytes()
+
+			path := filepath.Join("testdata", sc.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%s; run go test -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("transcript differs from %s; run go test -update and review the diff\n%s", path, diff(string(want), string(got)))
+			}
+		})
+	}
+}
+
+// diff shows the first line at which the transcripts differ.
+func diff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			return fmt.Sprintf("line %d:\n- %s\n+ %s", i+1, wl, gl)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/audit"
+	"github.com/fanatic/git-training/dryrun"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/store"
+)
+
+// AdminHandler serves the maintenance endpoints under /admin/. Requests must
+// send the admin token as a bearer token; without a token configured the
+// endpoints are disabled.
+type AdminHandler struct {
+	*Engine
+	Token string
+	// Journal, in dry-run mode, holds the writes the bot didn't make.
+	Journal *dryrun.Journal
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.Token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(h.Token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/admin/reconcile":
+		h.reconcile(w, r)
+	case "/admin/audit":
+		h.audit(w, r)
+	case "/admin/outbox":
+		h.outbox(w, r)
+	case "/admin/outbox/retry":
+		h.retry(w, r)
+	case "/admin/dry-run":
+		h.dryRun(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// reconcile reconciles one repository, given as ?installation=<id>&repo=<owner>/<name>,
+// or every repository of every installation when no repository is given.
+func (h *AdminHandler) reconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		results []Reconciliation
+		err     error
+	)
+	if repo := r.URL.Query().Get("repo"); repo != "" {
+		installationID, perr := strconv.ParseInt(r.URL.Query().Get("installation"), 10, 64)
+		parts := strings.SplitN(repo, "/", 2)
+		if perr != nil || len(parts) != 2 {
+			http.Error(w, "installation and repo=<owner>/<name> are required together", http.StatusBadRequest)
+			return
+		}
+		results, err = h.Reconcile(r.Context(), installationID, parts[0], parts[1])
+	} else {
+		results, err = h.ReconcileAll(r.Context())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logrus.WithError(err).Error("Failed to reconcile")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Results []Reconciliation `json:"results"`
+		Error   string           `json:"error,omitempty"`
+	}{results, errorString(err)})
+}
+
+// audit lists the audit log, oldest entry first.
+func (h *AdminHandler) audit(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.Audit.List()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list audit log")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Entries []audit.Entry `json:"entries"`
+	}{entries})
+}
+
+// outbox lists the messages waiting to be delivered and the dead letters.
+func (h *AdminHandler) outbox(w http.ResponseWriter, r *http.Request) {
+	if h.Outbox == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pending, err := h.Outbox.Pending()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list outbox")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	dead, err := h.Outbox.Dead()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list dead letters")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Pending []outbox.Message `json:"pending"`
+		Dead    []outbox.Message `json:"dead"`
+	}{pending, dead})
+}
+
+// retry queues a dead letter, given as ?id=<id>, for delivery again, or every
+// dead letter when no id is given.
+func (h *AdminHandler) retry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if h.Outbox == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	n, err := h.Outbox.Retry(r.URL.Query().Get("id"))
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logrus.WithError(err).Error("Failed to retry dead letters")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Retried int    `json:"retried"`
+		Error   string `json:"error,omitempty"`
+	}{n, errorString(err)})
+}
+
+// dryRun lists the writes the bot would have made, oldest first.
+func (h *AdminHandler) dryRun(w http.ResponseWriter, r *http.Request) {
+	if h.Journal == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Entries []dryrun.Entry `json:"entries"`
+	}{h.Journal.Entries()})
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
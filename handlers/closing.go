@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shurcooL/githubv4"
+	"github.com/sirupsen/logrus"
+)
+
+// closingKeywords are the words that make GitHub close the issue a pull
+// request's body mentions after them when the pull request is merged.
+var closingKeywords = map[string]bool{
+	"close": true, "closes": true, "closed": true,
+	"fix": true, "fixes": true, "fixed": true,
+	"resolve": true, "resolves": true, "resolved": true,
+}
+
+var (
+	issueRef = regexp.MustCompile(`^(?:([\w.-]+)/([\w.-]+))?#(\d+)$`)
+	issueURL = regexp.MustCompile(`^https?://([^/]+)/([\w.-]+)/([\w.-]+)/issues/(\d+)/?$`)
+)
+
+// closes reads a pull request body the way GitHub does, and reports whether
+// it closes the issue, with a closing keyword in any case and an optional
+// colon before a reference to it, and whether it mentions the issue at all.
+// References are #N, owner/repo#N and the issue's URL on webURL.
+func closes(body, owner, repo string, number int, webURL string) (closed, mentioned bool) {
+	host := ""
+	if u, err := url.Parse(webURL); err == nil {
+		host = u.Host
+	}
+	refersTo := func(word string) bool {
+		var refOwner, refRepo, refNumber string
+		if m := issueRef.FindStringSubmatch(word); m != nil {
+			refOwner, refRepo, refNumber = m[1], m[2], m[3]
+		} else if m := issueURL.FindStringSubmatch(word); m != nil && (host == "" || strings.EqualFold(m[1], host)) {
+			refOwner, refRepo, refNumber = m[2], m[3], m[4]
+		} else {
+			return false
+		}
+		if refOwner != "" && !(strings.EqualFold(refOwner, owner) && strings.EqualFold(refRepo, repo)) {
+			return false
+		}
+		n, err := strconv.Atoi(refNumber)
+		return err == nil && n == number
+	}
+
+	words := strings.Fields(body)
+	for i, word := range words {
+		if !refersTo(strings.TrimRight(word, ".,;:!?)")) {
+			continue
+		}
+		mentioned = true
+		if i > 0 && closingKeywords[strings.ToLower(strings.TrimSuffix(words[i-1], ":"))] {
+			return true, true
+		}
+	}
+	return false, mentioned
+}
+
+// linkedIssues asks the GraphQL API which issues in the event's repository
+// the pull request will close, which includes those linked to it from the
+// sidebar rather than its body. A server too old to know is treated as having
+// none.
+func (e *Engine) linkedIssues(ctx context.Context, event Event) []int {
+	client, err := e.ClientCreator.NewInstallationV4Client(event.InstallationID)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to create a GraphQL client")
+		return nil
+	}
+	var q struct {
+		Repository struct {
+			PullRequest struct {
+				ClosingIssuesReferences struct {
+					Nodes []struct {
+						Number     int
+						Repository struct {
+							Owner struct {
+								Login string
+							}
+							Name string
+						}
+					}
+				} `graphql:"closingIssuesReferences(first: 25)"`
+			} `graphql:"pullRequest(number: $number)"`
+		} `graphql:"repository(owner: $owner, name: $repo)"`
+	}
+	err = client.Query(ctx, &q, map[string]interface{}{
+		"owner":  githubv4.String(event.Owner),
+		"repo":   githubv4.String(event.Repo),
+		"number": githubv4.Int(event.PullRequest.GetNumber()),
+	})
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to look up the issues #%d closes", event.PullRequest.GetNumber())
+		return nil
+	}
+	var numbers []int
+	for _, issue := range q.Repository.PullRequest.ClosingIssuesReferences.Nodes {
+		if strings.EqualFold(issue.Repository.Owner.Login, event.Owner) && strings.EqualFold(issue.Repository.Name, event.Repo) {
+			numbers = append(numbers, issue.Number)
+		}
+	}
+	return numbers
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import "testing"
+
+func TestCloses(t *testing.T) {
+	for _, tc := range []struct {
+		body              string
+		closed, mentioned bool
+	}{
+		{"Resolves #1", true, true},
+		{"fixes #1.", true, true},
+		{"Fixes: #1", true, true},
+		{"Fixes: octo/hello#1", true, true},
+		{"CLOSES: Octo/Hello#1, thanks", true, true},
+		{"Resolved octo/hello#1", true, true},
+		{"Fixes: other/hello#1", false, false},
+		{"Fixes: octo/other#1", false, false},
+		{"Fixes: octo/hello#12", false, false},
+		{"See octo/hello#1", false, true},
+		{"Fixes octo/hello #1", false, true},
+		{"Closes: https://github.com/octo/hello/issues/1", true, true},
+		{"Closes https://ghe.example.com/octo/hello/issues/1", false, false},
+		{"Adds my file", false, false},
+	} {
+		closed, mentioned := closes(tc.body, "octo", "hello", 1, "https://github.com")
+		if closed != tc.closed || mentioned != tc.mentioned {
+			t.Errorf("%q: got closed %t, mentioned %t; want %t, %t", tc.body, closed, mentioned, tc.closed, tc.mentioned)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/content"
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+)
+
+// Command is a slash command from an issue or pull request comment.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// ParseCommand returns the first slash command in a comment body. Commands
+// must start a line; anything inside a quote or code block is ignored.
+func ParseCommand(body string) (Command, bool) {
+	inFence := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence || !strings.HasPrefix(line, "/") {
+			continue
+		}
+
+		fields := strings.Fields(line[1:])
+		if len(fields) == 0 {
+			continue
+		}
+		return Command{Name: strings.ToLower(fields[0]), Args: fields[1:]}, true
+	}
+	return Command{}, false
+}
+
+// Command runs a trainee's slash command and replies in the thread it was
+// posted in.
+func (e *Engine) Command(ctx context.Context, event Event, cmd Command) error {
+	ctx = outbox.WithOrigin(ctx, event.DeliveryID)
+	client, err := e.NewInstallationClient(event.InstallationID)
+	if err != nil {
+		return err
+	}
+
+	p, err := e.Progress.Get(ctx, client, event.key())
+	if err != nil {
+		return err
+	}
+
+	lang := ""
+	if p != nil {
+		lang = p.Lang
+	}
+	reply := func(body string) error {
+		if p != nil {
+			body += "\n\n" + e.marker(p).String()
+		}
+		if err := e.reply(ctx, client, event, body); err != nil {
+			return err
+		}
+		if p == nil {
+			return nil
+		}
+		return e.Progress.Save(ctx, p)
+	}
+	say := func(key string, data map[string]interface{}) error {
+		body, err := e.say(lang, key, data)
+		if err != nil {
+			return err
+		}
+		return reply(body)
+	}
+	trainee := map[string]interface{}{"Trainee": event.Trainee}
+
+	logrus.Infof("Running /%s for %s", cmd.Name, event.Trainee)
+	if instructorCommands[cmd.Name] {
+		return e.instruct(ctx, client, event, cmd, lang)
+	}
+
+	switch cmd.Name {
+	case "hint", "status", "skip":
+		if p == nil || p.Completed() {
+			return say("not_taking_course", trainee)
+		}
+	case "restart", "lang":
+		if p == nil {
+			return say("not_started", trainee)
+		}
+	default:
+		return say("help", trainee)
+	}
+
+	current, _ := e.Course.Step(p.Step)
+	chain := content.Chain(lang)
+	switch cmd.Name {
+	case "hint":
+		branch := event.DefaultBranch
+		if branch == "" {
+			if branch, err = defaultBranch(ctx, client, event.Owner, event.Repo); err != nil {
+				return err
+			}
+		}
+		return e.hint(ctx, p, current, branch, say)
+	case "status":
+		return say("status", e.status(p))
+	case "skip":
+		if p.Paused {
+			return say("paused", trainee)
+		}
+		if !current.Optional {
+			return say("not_optional", map[string]interface{}{"Trainee": event.Trainee, "Step": current.TitleIn(chain)})
+		}
+		return e.complete(ctx, client, p, current)
+	case "restart":
+		issue := p.Issue
+		if !event.Issue.IsPullRequest() {
+			issue = event.Issue.GetNumber()
+		}
+		return e.restart(ctx, client, p, issue)
+	case "lang":
+		available := e.availableLanguages()
+		if len(cmd.Args) == 0 {
+			return say("lang_current", map[string]interface{}{"Trainee": event.Trainee, "Language": e.language(lang), "Available": available})
+		}
+		if !e.Content.Supports(cmd.Args[0]) {
+			return say("lang_unsupported", map[string]interface{}{"Trainee": event.Trainee, "Lang": cmd.Args[0], "Available": available})
+		}
+		lang = content.Normalize(cmd.Args[0])
+		p.Lang = lang
+		if err := e.Progress.Save(ctx, p); err != nil {
+			return err
+		}
+		return say("lang_set", map[string]interface{}{"Trainee": event.Trainee, "Language": e.language(lang)})
+	}
+	return nil
+}
+
+func (e *Engine) hint(ctx context.Context, p *progress.Progress, step course.Step, defaultBranch string, say func(string, map[string]interface{}) error) error {
+	chain := content.Chain(p.Lang)
+	title := step.TitleIn(chain)
+	hints := step.HintsIn(chain)
+	if len(hints) == 0 {
+		return say("no_hints", map[string]interface{}{"Trainee": p.Login, "Step": title})
+	}
+
+	n := p.Hints
+	if n >= len(hints) {
+		n = len(hints) - 1
+	}
+	vars := e.progressVars(p)
+	vars.DefaultBranch = defaultBranch
+	text, err := course.Render(hints[n], vars)
+	if err != nil {
+		return err
+	}
+
+	p.Hint()
+	if err := e.Progress.Save(ctx, p); err != nil {
+		return err
+	}
+
+	return say("hint", map[string]interface{}{
+		"N":     n + 1,
+		"Total": len(hints),
+		"Step":  title,
+		"Text":  text,
+		"More":  n+1 < len(hints),
+	})
+}
+
+// statusStep is a line of the status message's checklist.
+type statusStep struct {
+	Title   string
+	Done    bool
+	Current bool
+}
+
+func (e *Engine) status(p *progress.Progress) map[string]interface{} {
+	chain := content.Chain(p.Lang)
+	current := e.Course.Index(p.Step)
+	var steps []statusStep
+	for i, step := range e.Course.Steps {
+		steps = append(steps, statusStep{Title: step.TitleIn(chain), Done: i < current, Current: i == current})
+	}
+
+	return map[string]interface{}{
+		"Trainee":  p.Login,
+		"Course":   e.Course.TitleIn(chain),
+		"Started":  p.StartedAt.Format("2 Jan 2006"),
+		"Steps":    steps,
+		"Since":    p.StepStartedAt.Format("2 Jan 2006 15:04 MST"),
+		"Attempts": p.Attempts,
+		"Hints":    p.Hints,
+	}
+}
+
+// availableLanguages lists the languages a trainee can switch to.
+func (e *Engine) availableLanguages() string {
+	var locales []string
+	for _, locale := range e.Content.Locales() {
+		locales = append(locales, "`"+locale+"`")
+	}
+	return strings.Join(locales, ", ")
+}
+
+// complete finishes the step without waiting for its trigger and performs its
+// actions, handing the trainee their next task.
+func (e *Engine) complete(ctx context.Context, client *github.Client, p *progress.Progress, step course.Step) error {
+	p.Advance(e.Course.Next(step.ID))
+	if err := e.Progress.Save(ctx, p); err != nil {
+		return err
+	}
+
+	return e.replay(ctx, client, step, e.progressVars(p), p)
+}
+
+// restart starts a new run of the course in the given training issue.
+func (e *Engine) restart(ctx context.Context, client *github.Client, p *progress.Progress, issue int) error {
+	restarted := progress.New(p.Key, e.Course.ID, e.Course.Steps[0].ID)
+	restarted.Run = p.Run + 1
+	restarted.Posts = p.Posts
+	restarted.Issue = issue
+	restarted.Lang = p.Lang
+	return e.complete(ctx, client, restarted, e.Course.Steps[0])
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// diff maps each file a pull request changes to the positions in its diff of
+// the file's lines, by line number in the pull request's version.
+type diff map[string]map[int]int
+
+// pullRequestDiff fetches the files the pull request changes and works out
+// where their lines are in its diff, which is where review comments go.
+func pullRequestDiff(ctx context.Context, client *github.Client, owner, repo string, number int) (diff, error) {
+	d := diff{}
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, number, opt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the files of #%d", number)
+		}
+		for _, f := range files {
+			d[f.GetFilename()] = positions(f.GetPatch())
+		}
+		if resp.NextPage == 0 {
+			return d, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// position returns the diff position of the line of the file, and false if
+// the file isn't in the diff or the line isn't shown in it.
+func (d diff) position(path string, line int) (int, bool) {
+	p, ok := d[path][line]
+	return p, ok
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// positions reads a file's unified diff and maps the line numbers of the new
+// version that appear in it to their positions. The line below the first
+// hunk header is position 1, and the count carries on through the headers
+// of the hunks after it.
+func positions(patch string) map[int]int {
+	lines := map[int]int{}
+	if patch == "" {
+		return lines
+	}
+	position, line := 0, 0
+	for i, text := range strings.Split(patch, "\n") {
+		if m := hunkHeader.FindStringSubmatch(text); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			if i > 0 {
+				position++
+			}
+			continue
+		}
+		position++
+		switch {
+		case strings.HasPrefix(text, "+"), strings.HasPrefix(text, " "):
+			lines[line] = position
+			line++
+		case text == "":
+			// An unchanged blank line whose leading space was trimmed.
+			lines[line] = position
+			line++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		patch string
+		want  map[int]int
+	}{
+		{"empty", "", map[int]int{}},
+		{
+			"one hunk",
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c",
+			map[int]int{1: 1, 2: 3, 3: 4},
+		},
+		{
+			"two hunks",
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n@@ -10,2 +10,3 @@\n j\n+k\n l",
+			map[int]int{1: 1, 2: 3, 3: 4, 10: 6, 11: 7, 12: 8},
+		},
+		{
+			"no newline at the end of either version",
+			"@@ -1 +1 @@\n-old\n\\ No newline at end of file\n+new\n\\ No newline at end of file",
+			map[int]int{1: 3},
+		},
+		{
+			"no newline after a later hunk",
+			"@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -8,2 +8,2 @@\n h\n-i\n\\ No newline at end of file\n+I\n\\ No newline at end of file",
+			map[int]int{1: 2, 2: 3, 8: 5, 9: 8},
+		},
+		{
+			"blank context line",
+			"@@ -1,3 +1,3 @@\n a\n\n-c\n+C",
+			map[int]int{1: 1, 2: 2, 3: 4},
+		},
+	} {
+		if got := positions(tc.patch); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
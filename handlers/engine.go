@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/audit"
+	"github.com/fanatic/git-training/content"
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+// Engine runs a course definition against the webhook events delivered to
+// the handlers, moving each trainee through it one step at a time.
+type Engine struct {
+	githubapp.ClientCreator
+	Course   *course.Course
+	Progress progress.Source
+	Audit    *audit.Log
+	Outbox   *outbox.Outbox
+	Content  *content.Content
+	Links    course.Links
+}
+
+// Event is the part of a webhook delivery the engine needs, normalized across
+// event types by the handlers.
+type Event struct {
+	Type           string
+	Action         string
+	DeliveryID     string
+	InstallationID int64
+	Owner          string
+	Repo           string
+	DefaultBranch  string
+	Trainee        string
+	Branch         string
+	// SHA is the commit the event is about, and Files the files it changed,
+	// when the event has them.
+	SHA         string
+	Files       []string
+	Issue       *github.Issue
+	PullRequest *github.PullRequest
+}
+
+func (ev Event) key() progress.Key {
+	return progress.Key{
+		InstallationID: ev.InstallationID,
+		Owner:          ev.Owner,
+		Repo:           ev.Repo,
+		Login:          ev.Trainee,
+	}
+}
+
+// Run completes the trainee's current step if the event triggers it and its
+// validations pass. Events for steps the trainee has already completed are
+// ignored, and events for steps they haven't reached yet get a reminder of
+// what to do first.
+func (e *Engine) Run(ctx context.Context, event Event) error {
+	ctx = outbox.WithOrigin(ctx, event.DeliveryID)
+	client, err := e.NewInstallationClient(event.InstallationID)
+	if err != nil {
+		return err
+	}
+
+	p, err := e.Progress.Get(ctx, client, event.key())
+	if err != nil {
+		return err
+	}
+	started := p != nil && !p.Completed()
+	current := e.Course.Steps[0]
+	if started {
+		current, _ = e.Course.Step(p.Step)
+	}
+	if started && p.Paused {
+		logrus.Infof("Dropping %s %s event because %s's course is paused", event.Type, event.Action, event.Trainee)
+		return nil
+	}
+
+	ahead := false
+	if !current.On.Matches(event.Type, event.Action) {
+		ahead = started && e.isAhead(current, event)
+		if !ahead {
+			logrus.Infof("Dropping %s %s event because %s is on step %s", event.Type, event.Action, event.Trainee, current.ID)
+			return nil
+		}
+	}
+
+	issueNumber, err := e.linkedIssue(ctx, client, event, p)
+	if err != nil || issueNumber == 0 {
+		return err
+	}
+	if started && event.Issue != nil && event.Issue.GetNumber() != issueNumber {
+		logrus.Infof("Dropping %s %s event because #%d isn't %s's training issue #%d", event.Type, event.Action, event.Issue.GetNumber(), event.Trainee, issueNumber)
+		return nil
+	}
+	if started && p.Issue == 0 {
+		p.Issue = issueNumber
+	}
+	if ahead {
+		return e.remind(ctx, client, event, p, current)
+	}
+
+	vars := e.vars(event, issueNumber, p)
+	failed, err := e.validate(ctx, client, current, event, vars)
+	if err != nil {
+		return errors.Wrapf(err, "failed to validate step %s", current.ID)
+	}
+	if failed != nil {
+		if started {
+			p.Attempt()
+			if err := e.Progress.Save(ctx, p); err != nil {
+				return err
+			}
+			return e.correct(ctx, client, failed, vars, p)
+		}
+		return nil
+	}
+
+	if !started {
+		run, posts := 1, 0
+		if p != nil {
+			run, posts = p.Run+1, p.Posts
+		}
+		previous := p
+		p = progress.New(event.key(), e.Course.ID, current.ID)
+		p.Run = run
+		p.Posts = posts
+		p.Issue = issueNumber
+		p.Lang = e.startLanguage(event, previous)
+		if err := e.labelIssue(ctx, client, event.InstallationID, event.Owner, event.Repo, issueNumber); err != nil {
+			return err
+		}
+	}
+	if vars.Branch != "" {
+		p.Branch = vars.Branch
+	}
+	if vars.PRNumber != 0 {
+		p.PullRequest = vars.PRNumber
+	}
+	p.Advance(e.Course.Next(current.ID))
+	if err := e.Progress.Save(ctx, p); err != nil {
+		return err
+	}
+
+	logrus.Infof("Completed step %s for %s", current.ID, event.Trainee)
+	for _, action := range current.Actions {
+		if err := e.perform(ctx, client, action, vars, p); err != nil {
+			return errors.Wrapf(err, "failed to perform step %s", current.ID)
+		}
+	}
+
+	return e.Progress.Save(ctx, p)
+}
+
+// isAhead reports whether the event triggers a step after the current one.
+func (e *Engine) isAhead(current course.Step, event Event) bool {
+	from := e.Course.Index(current.ID)
+	for _, step := range e.Course.Triggered(event.Type, event.Action) {
+		if e.Course.Index(step.ID) > from {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) remind(ctx context.Context, client *github.Client, event Event, p *progress.Progress, current course.Step) error {
+	vars := e.vars(event, p.Issue, p)
+	vars.Step = current.TitleIn(content.Chain(p.Lang))
+
+	logrus.Infof("Reminding %s to finish step %s", event.Trainee, current.ID)
+	for _, action := range e.Course.OutOfOrder {
+		if err := e.perform(ctx, client, action, vars, p); err != nil {
+			return errors.Wrap(err, "failed to send reminder")
+		}
+	}
+	return e.Progress.Save(ctx, p)
+}
+
+// marker numbers the next post to the trainee and returns the marker to hide
+// in it. Callers save the progress once they've posted.
+func (e *Engine) marker(p *progress.Progress) progress.Marker {
+	p.Posts++
+	return progress.Marker{
+		Course:  p.Course,
+		Step:    p.Step,
+		Trainee: p.Login,
+		Run:     p.Run,
+		Issue:   p.Issue,
+		PR:      p.PullRequest,
+		Branch:  p.Branch,
+		Lang:    p.Lang,
+		Paused:  p.Paused,
+		Hints:   p.Hints,
+		Seq:     p.Posts,
+	}
+}
+
+// vars builds the template vars for the event, falling back to the branch and
+// pull request recorded in the trainee's progress.
+func (e *Engine) vars(event Event, issueNumber int, p *progress.Progress) course.Vars {
+	vars := course.Vars{
+		Trainee:       event.Trainee,
+		Owner:         event.Owner,
+		Repo:          event.Repo,
+		Branch:        strings.TrimPrefix(event.Branch, "refs/heads/"),
+		DefaultBranch: event.DefaultBranch,
+		IssueNumber:   issueNumber,
+	}
+	if event.PullRequest != nil {
+		vars.PRNumber = event.PullRequest.GetNumber()
+	}
+	if p != nil && !p.Completed() {
+		if vars.Branch == "" {
+			vars.Branch = p.Branch
+		}
+		if vars.PRNumber == 0 {
+			vars.PRNumber = p.PullRequest
+		}
+	}
+	return e.withLinks(vars)
+}
+
+// progressVars builds the template vars from the trainee's progress alone.
+func (e *Engine) progressVars(p *progress.Progress) course.Vars {
+	return e.withLinks(course.Vars{
+		Trainee:     p.Login,
+		Owner:       p.Owner,
+		Repo:        p.Repo,
+		Branch:      p.Branch,
+		IssueNumber: p.Issue,
+		PRNumber:    p.PullRequest,
+	})
+}
+
+// withLinks adds the addresses messages link to.
+func (e *Engine) withLinks(vars course.Vars) course.Vars {
+	vars.WebURL = e.Links.WebURL
+	vars.DocsURL = e.Links.DocsURL
+	vars.Videos = e.Links.Videos
+	return vars
+}
+
+// defaultBranch looks up the repository's default branch, for vars that
+// weren't built from a webhook carrying it.
+func defaultBranch(ctx context.Context, client *github.Client, owner, repo string) (string, error) {
+	r, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get repository")
+	}
+	return r.GetDefaultBranch(), nil
+}
+
+// failure is a validation the event didn't pass, and what it found.
+type failure struct {
+	validation course.Validation
+	check      course.Check
+}
+
+// validate runs the step's validations against the event, and returns the
+// first that fails, or nil if they all pass.
+func (e *Engine) validate(ctx context.Context, client *github.Client, step course.Step, event Event, vars course.Vars) (*failure, error) {
+	for _, v := range step.Validations {
+		failed := &failure{validation: v}
+		switch v.Type {
+		case course.ValidateAssigneeIsAuthor:
+			author := event.Issue.GetUser()
+			isAssigned := false
+			for _, assignee := range event.Issue.Assignees {
+				if assignee.GetLogin() == author.GetLogin() {
+					isAssigned = true
+				}
+			}
+			if !isAssigned {
+				logrus.Infof("Dropping %s event because user %s != assignees %v", event.Action, author, event.Issue.Assignees)
+				return failed, nil
+			}
+		case course.ValidateBodyContains:
+			text, err := course.Render(v.Text, vars)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.Contains(event.PullRequest.GetBody(), text) {
+				logrus.Infof("Dropping %s event because the body doesn't contain %q", event.Action, text)
+				return failed, nil
+			}
+		case course.ValidateClosesIssue:
+			closed, mentioned := closes(event.PullRequest.GetBody(), event.Owner, event.Repo, vars.IssueNumber, e.Links.WebURL)
+			if !closed {
+				for _, n := range e.linkedIssues(ctx, event) {
+					closed = closed || n == vars.IssueNumber
+				}
+			}
+			if !closed {
+				logrus.Infof("Dropping %s event because the pull request doesn't close #%d", event.Action, vars.IssueNumber)
+				failed.check = course.Check{Found: mentioned}
+				return failed, nil
+			}
+		case course.ValidateMinCommits:
+			if event.PullRequest.GetCommits() < v.Count {
+				logrus.Infof("Dropping %s event because it doesn't contain %d commits", event.Action, v.Count)
+				return failed, nil
+			}
+		case course.ValidateFileContent:
+			check, ok, err := e.checkFile(ctx, client, v, event, vars)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				logrus.Infof("Dropping %s event because %s doesn't contain %q", event.Type, check.Path, check.Expected)
+				failed.check = check
+				return failed, nil
+			}
+		case course.ValidateBranchName:
+			check, ok, err := v.Branch.Check(event.Trainee, event.Branch)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				logrus.Infof("Dropping %s event because branch %s doesn't follow %s", event.Type, event.Branch, check.Expected)
+				failed.check = check
+				return failed, nil
+			}
+		case course.ValidateLineChanged:
+			check, ok, err := e.checkLine(ctx, client, v, event, vars)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				logrus.Infof("Dropping %s event because line %d of %s wasn't replaced", event.Action, check.Line, check.Path)
+				failed.check = check
+				return failed, nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown validation %q", v.Type)
+		}
+	}
+	return nil, nil
+}
+
+// correct performs the actions of a failed validation, telling the trainee
+// what's wrong.
+func (e *Engine) correct(ctx context.Context, client *github.Client, failed *failure, vars course.Vars, p *progress.Progress) error {
+	vars.Check = failed.check
+	for _, action := range failed.validation.Fail {
+		if err := e.perform(ctx, client, action, vars, p); err != nil {
+			return errors.Wrapf(err, "failed to correct %s", failed.validation.Type)
+		}
+	}
+	return nil
+}
+
+// replay performs a step's actions outside of the webhook that would normally
+// trigger them. Actions on a pull request are skipped while the trainee
+// hasn't opened one. The progress is saved again afterwards, with the posts
+// counted.
+func (e *Engine) replay(ctx context.Context, client *github.Client, step course.Step, vars course.Vars, p *progress.Progress) error {
+	for _, action := range step.Actions {
+		if action.Target == course.TargetPullRequest && vars.PRNumber == 0 {
+			continue
+		}
+		if err := e.perform(ctx, client, action, vars, p); err != nil {
+			return errors.Wrapf(err, "failed to perform step %s", step.ID)
+		}
+	}
+	return e.Progress.Save(ctx, p)
+}
+
+// perform carries out an action for the trainee, hiding their progress
+// marker in what it posts.
+func (e *Engine) perform(ctx context.Context, client *github.Client, action course.Action, vars course.Vars, p *progress.Progress) error {
+	if vars.DefaultBranch == "" {
+		branch, err := defaultBranch(ctx, client, vars.Owner, vars.Repo)
+		if err != nil {
+			return err
+		}
+		vars.DefaultBranch = branch
+	}
+
+	chain := content.Chain(p.Lang)
+	body, err := course.RenderFirst(action.Texts(chain), vars)
+	if err != nil {
+		return err
+	}
+	marker := "\n\n" + e.marker(p).String()
+
+	number := vars.IssueNumber
+	if action.Target == course.TargetPullRequest {
+		number = vars.PRNumber
+	}
+
+	switch action.Type {
+	case course.ActionComment:
+		return e.post(ctx, client, outbox.Comment(p.InstallationID, vars.Owner, vars.Repo, number, body+marker))
+	case course.ActionReview, course.ActionApprove:
+		review := github.PullRequestReviewRequest{
+			Event: String(action.Event),
+		}
+		if action.Type == course.ActionApprove {
+			review.Event = String("APPROVE")
+		} else if action.Event == "" {
+			review.Event = String("COMMENT")
+		}
+		var d diff
+		for _, c := range action.Comments {
+			path, err := course.Render(c.Path, vars)
+			if err != nil {
+				return err
+			}
+			commentBody, err := course.RenderFirst(c.Texts(chain), vars)
+			if err != nil {
+				return err
+			}
+
+			position := c.Position
+			if c.Line > 0 {
+				if d == nil {
+					if d, err = pullRequestDiff(ctx, client, vars.Owner, vars.Repo, number); err != nil {
+						return err
+					}
+				}
+				var ok bool
+				if position, ok = d.position(path, c.Line); !ok {
+					// GitHub rejects comments outside the diff, so this one
+					// goes in the review's body instead.
+					logrus.Infof("Moving the review comment on line %d of %s into the body, because it isn't in the diff of #%d", c.Line, path, number)
+					note, err := e.say(p.Lang, "review_comment_outside_diff", map[string]interface{}{"Path": path, "Line": c.Line, "Body": commentBody})
+					if err != nil {
+						return err
+					}
+					body += "\n\n" + note
+					continue
+				}
+			}
+			review.Comments = append(review.Comments, &github.DraftReviewComment{
+				Path:     String(path),
+				Position: Int(position),
+				Body:     String(commentBody),
+			})
+		}
+		review.Body = String(body + marker)
+		return e.post(ctx, client, outbox.Review(p.InstallationID, vars.Owner, vars.Repo, number, review))
+	default:
+		return fmt.Errorf("unknown action %q", action.Type)
+	}
+}
+
+// post queues a write to GitHub in the outbox. Without an outbox the write is
+// made straight away.
+func (e *Engine) post(ctx context.Context, client *github.Client, m outbox.Message) error {
+	if e.Outbox == nil {
+		return outbox.Send(ctx, client, m)
+	}
+	return e.Outbox.Enqueue(ctx, m)
+}
+
+// reply comments on an issue or pull request.
+func (e *Engine) reply(ctx context.Context, client *github.Client, event Event, body string) error {
+	return e.post(ctx, client, outbox.Comment(event.InstallationID, event.Owner, event.Repo, event.Issue.GetNumber(), body))
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// checkFile fetches the file the validation names, as of the event's commit,
+// and reports whether it holds the expected text. Surrounding whitespace and
+// line endings don't count.
+func (e *Engine) checkFile(ctx context.Context, client *github.Client, v course.Validation, event Event, vars course.Vars) (course.Check, bool, error) {
+	var check course.Check
+	var err error
+	if check.Path, err = course.Render(v.Path, vars); err != nil {
+		return check, false, err
+	}
+	if check.Expected, err = course.Render(v.Text, vars); err != nil {
+		return check, false, err
+	}
+	check.Changed = event.Files
+
+	ref := event.SHA
+	if ref == "" {
+		ref = vars.Branch
+	}
+	content, found, err := fileAt(ctx, client, event.Owner, event.Repo, check.Path, ref)
+	if err != nil {
+		return check, false, err
+	}
+	check.Found = found
+	check.Content = strings.TrimRight(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	return check, found && normalize(content) == normalize(check.Expected), nil
+}
+
+// fileAt returns the content of the file at the ref, and false if there's no
+// such file.
+func fileAt(ctx context.Context, client *github.Client, owner, repo, path, ref string) (string, bool, error) {
+	file, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to get %s at %s", path, ref)
+	}
+	if file == nil {
+		// A directory.
+		return "", false, nil
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to decode %s at %s", path, ref)
+	}
+	return content, true, nil
+}
+
+// checkLine compares the pull request's head with the commit the bot last
+// reviewed, and reports whether the validation's line of the file changed
+// and no longer holds the validation's text, the way it was before the
+// review asked for it to be replaced.
+func (e *Engine) checkLine(ctx context.Context, client *github.Client, v course.Validation, event Event, vars course.Vars) (course.Check, bool, error) {
+	check := course.Check{Line: v.Line}
+	var err error
+	if check.Path, err = course.Render(v.Path, vars); err != nil {
+		return check, false, err
+	}
+	if check.Expected, err = course.Render(v.Text, vars); err != nil {
+		return check, false, err
+	}
+
+	pr := event.PullRequest
+	head := event.SHA
+	if head == "" {
+		head = pr.GetHead().GetSHA()
+	}
+	reviewed, err := lastReviewed(ctx, client, event.Owner, event.Repo, pr.GetNumber())
+	if err != nil {
+		return check, false, err
+	}
+	if reviewed == "" {
+		reviewed = pr.GetBase().GetSHA()
+	}
+
+	comparison, _, err := client.Repositories.CompareCommits(ctx, event.Owner, event.Repo, reviewed, head)
+	if err != nil {
+		return check, false, errors.Wrapf(err, "failed to compare %s with %s", reviewed, head)
+	}
+	changed := false
+	for _, f := range comparison.Files {
+		check.Changed = append(check.Changed, f.GetFilename())
+		changed = changed || f.GetFilename() == check.Path
+	}
+
+	after, found, err := fileAt(ctx, client, event.Owner, event.Repo, check.Path, head)
+	if err != nil {
+		return check, false, err
+	}
+	check.Found = found
+	check.Content = line(after, v.Line)
+	if !found || !changed {
+		return check, false, nil
+	}
+	before, _, err := fileAt(ctx, client, event.Owner, event.Repo, check.Path, reviewed)
+	if err != nil {
+		return check, false, err
+	}
+	return check, line(before, v.Line) != check.Content && normalize(check.Content) != normalize(check.Expected), nil
+}
+
+// lastReviewed returns the commit of the bot's latest review of the pull
+// request, or "" if it hasn't reviewed it.
+func lastReviewed(ctx context.Context, client *github.Client, owner, repo string, number int) (string, error) {
+	commit := ""
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := client.PullRequests.ListReviews(ctx, owner, repo, number, opts)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to list reviews of #%d", number)
+		}
+		for _, r := range reviews {
+			if _, ok := progress.ParseMarker(r.GetBody()); ok && r.GetUser().GetType() == "Bot" {
+				commit = r.GetCommitID()
+			}
+		}
+		if resp.NextPage == 0 {
+			return commit, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// line returns the nth line of the text, counting from 1, without its line
+// ending.
+func line(text string, n int) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return lines[n-1]
+}
+
+func normalize(text string) string {
+	return strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1))
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/githubtest"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+)
+
+// pullRequest opens a pull request on the fake server from a branch that
+// adds users/octocat.md, and returns it with a client for the repository.
+func pullRequest(t *testing.T) (*githubtest.Server, *githubtest.Trainee, *github.Client, *github.PullRequest) {
+	gh := githubtest.NewServer()
+	gh.AddRepo(1, "training", "hello-world")
+	sim := githubtest.NewSimulator(t, gh, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), "secret")
+	octocat := sim.Trainee("training/hello-world", "octocat")
+	octocat.CreateBranch("feat/octocat-1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+	number := octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "")
+
+	client, err := gh.ClientCreator().NewInstallationClient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, _, err := client.PullRequests.Get(context.Background(), "training", "hello-world", number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gh, octocat, client, pr
+}
+
+// review adds a review of the pull request at the branch's head.
+func review(gh *githubtest.Server, number int, user, body string) string {
+	repo := gh.Repo("training/hello-world")
+	commits := repo.Branches["feat/octocat-1"].Commits
+	sha := commits[len(commits)-1].SHA
+	for _, issue := range repo.Issues {
+		if issue.Number == number {
+			issue.Reviews = append(issue.Reviews, &githubtest.Review{User: user, Body: body, State: "COMMENTED", CommitID: sha})
+		}
+	}
+	return sha
+}
+
+func TestLastReviewed(t *testing.T) {
+	gh, octocat, client, pr := pullRequest(t)
+	defer gh.Close()
+	ctx := context.Background()
+	marker := progress.Marker{Course: "intro", Step: "step-6", Trainee: "octocat"}.String()
+
+	reviewed := func() string {
+		commit, err := lastReviewed(ctx, client, "training", "hello-world", pr.GetNumber())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return commit
+	}
+	if commit := reviewed(); commit != "" {
+		t.Errorf("before any review: got %q, want none", commit)
+	}
+
+	review(gh, pr.GetNumber(), "mona", marker)
+	review(gh, pr.GetNumber(), githubtest.BotLogin, "Looks good")
+	if commit := reviewed(); commit != "" {
+		t.Errorf("with no bot review carrying a marker: got %q, want none", commit)
+	}
+
+	var want string
+	for i := 0; i < 150; i++ {
+		octocat.Commit("feat/octocat-1", "notes.md", string(rune('a'+i%26)), "Take notes")
+		want = review(gh, pr.GetNumber(), githubtest.BotLogin, marker)
+	}
+	if commit := reviewed(); commit != want {
+		t.Errorf("after 150 reviews: got %q, want the last one's commit %q", commit, want)
+	}
+}
+
+func TestCheckLine(t *testing.T) {
+	v := course.Validation{Type: course.ValidateLineChanged, Path: "users/{{.Trainee}}.md", Line: 1, Text: "Hello, world!"}
+	vars := course.Vars{Trainee: "octocat"}
+
+	for _, tc := range []struct {
+		name    string
+		review  bool
+		path    string
+		content string
+		want    bool
+		line    string
+	}{
+		{"unchanged since the review", true, "", "", false, "Hello, world!"},
+		{"another file changed", true, "notes.md", "Notes\n", false, "Hello, world!"},
+		{"line only reformatted", true, "users/octocat.md", "Hello, world!  \n", false, "Hello, world!  "},
+		{"line replaced", true, "users/octocat.md", "Talk is cheap. Show me the code.\n", true, "Talk is cheap. Show me the code."},
+		{"second line added", true, "users/octocat.md", "Hello, world!\nBye\n", false, "Hello, world!"},
+		{"line replaced before any review", false, "users/octocat.md", "Talk is cheap. Show me the code.\n", true, "Talk is cheap. Show me the code."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh, octocat, client, pr := pullRequest(t)
+			defer gh.Close()
+			if tc.review {
+				review(gh, pr.GetNumber(), githubtest.BotLogin, progress.Marker{Course: "intro", Step: "step-6", Trainee: "octocat"}.String())
+			}
+			if tc.path != "" {
+				octocat.Commit("feat/octocat-1", tc.path, tc.content, "Update")
+			}
+			commits := gh.Repo("training/hello-world").Branches["feat/octocat-1"].Commits
+			event := Event{Owner: "training", Repo: "hello-world", Trainee: "octocat", SHA: commits[len(commits)-1].SHA, PullRequest: pr}
+
+			check, ok, err := (&Engine{}).checkLine(context.Background(), client, v, event, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.want {
+				t.Errorf("got %t, want %t", ok, tc.want)
+			}
+			if check.Path != "users/octocat.md" || check.Expected != "Hello, world!" || check.Content != tc.line {
+				t.Errorf("got check %+v, want line %q of users/octocat.md", check, tc.line)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fanatic/git-training/audit"
+	"github.com/fanatic/git-training/content"
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+var instructorCommands = map[string]bool{
+	"advance": true,
+	"reset":   true,
+	"goto":    true,
+	"pause":   true,
+	"resume":  true,
+}
+
+// CommandTarget returns the trainee an instructor's command in a comment
+// body is for: the one named as "@user", or else author, the author of the
+// issue or pull request it was posted on. It returns false if the body holds
+// no instructor command.
+func CommandTarget(body, author string) (string, bool) {
+	cmd, ok := ParseCommand(body)
+	if !ok || !instructorCommands[cmd.Name] {
+		return "", false
+	}
+	target, _ := commandTarget(cmd, author)
+	return target, true
+}
+
+// commandTarget splits the trainee an instructor's command is for from the
+// rest of its arguments.
+func commandTarget(cmd Command, author string) (string, []string) {
+	if len(cmd.Args) > 0 && strings.HasPrefix(cmd.Args[0], "@") {
+		return strings.TrimPrefix(cmd.Args[0], "@"), cmd.Args[1:]
+	}
+	return author, cmd.Args
+}
+
+// instruct runs an instructor's command against a trainee: the one named as
+// "@user", or else the author of the issue or pull request it was posted on.
+// Only repository admins and maintainers may use these commands, and every
+// attempt is written to the audit log. Replies are in lang, the instructor's
+// own language.
+func (e *Engine) instruct(ctx context.Context, client *github.Client, event Event, cmd Command, lang string) error {
+	target, args := commandTarget(cmd, event.Issue.GetUser().GetLogin())
+
+	entry := audit.Entry{
+		Repo:    event.Owner + "/" + event.Repo,
+		Issue:   event.Issue.GetNumber(),
+		Actor:   event.Trainee,
+		Command: cmd.Name,
+		Target:  target,
+		Args:    cmd.Args,
+	}
+	answer := func(result, key, step string) (string, error) {
+		entry.Result = result
+		if e.Audit != nil {
+			if err := e.Audit.Record(entry); err != nil {
+				return "", err
+			}
+		}
+		return e.say(lang, key, map[string]interface{}{
+			"Trainee": event.Trainee,
+			"Command": cmd.Name,
+			"Target":  target,
+			"Step":    step,
+			"Steps":   e.stepIDs(),
+		})
+	}
+	reply := func(result, key, step string) error {
+		body, err := answer(result, key, step)
+		if err != nil {
+			return err
+		}
+		return e.reply(ctx, client, event, body)
+	}
+	chain := content.Chain(lang)
+
+	allowed, err := canInstruct(ctx, client, event.Owner, event.Repo, event.Trainee)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return reply("denied", "instructor_denied", "")
+	}
+	entry.Allowed = true
+
+	key := event.key()
+	key.Login = target
+	p, err := e.Progress.Get(ctx, client, key)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return reply("trainee not started", "instructor_not_started", "")
+	}
+
+	switch cmd.Name {
+	case "advance":
+		if p.Completed() {
+			return reply("already complete", "instructor_already_complete", "")
+		}
+		step, _ := e.Course.Step(p.Step)
+		if err := e.complete(ctx, client, p, step); err != nil {
+			return err
+		}
+		return reply("advanced past "+step.ID, "instructor_advanced", step.TitleIn(chain))
+	case "reset":
+		if err := e.restart(ctx, client, p, p.Issue); err != nil {
+			return err
+		}
+		return reply("reset", "instructor_reset", "")
+	case "goto":
+		if len(args) == 0 || e.Course.Index(args[0]) < 0 {
+			return reply("unknown step", "instructor_unknown_step", "")
+		}
+		step, _ := e.Course.Step(args[0])
+		if err := e.jump(ctx, client, p, step); err != nil {
+			return err
+		}
+		return reply("moved to "+step.ID, "instructor_moved", step.TitleIn(chain))
+	case "pause", "resume":
+		p.Paused = cmd.Name == "pause"
+		if err := e.Progress.Save(ctx, p); err != nil {
+			return err
+		}
+		var body string
+		if p.Paused {
+			body, err = answer("paused", "instructor_paused", "")
+		} else {
+			body, err = answer("resumed", "instructor_resumed", "")
+		}
+		if err != nil {
+			return err
+		}
+		// The reply carries the trainee's marker so that progress read from
+		// markers is paused too. Those are only read from the trainee's own
+		// issues and pull requests, so a command posted anywhere else is
+		// answered on their training issue.
+		number := event.Issue.GetNumber()
+		if !strings.EqualFold(event.Issue.GetUser().GetLogin(), target) {
+			number = p.Issue
+		}
+		body += "\n\n" + e.marker(p).String()
+		if err := e.post(ctx, client, outbox.Comment(event.InstallationID, event.Owner, event.Repo, number, body)); err != nil {
+			return err
+		}
+		return e.Progress.Save(ctx, p)
+	}
+	return nil
+}
+
+// permissionLevel is a collaborator's permission on a repository. Permission
+// is the legacy level, which reports maintainers as "write", so RoleName is
+// used where GitHub sends it.
+type permissionLevel struct {
+	Permission string `json:"permission"`
+	RoleName   string `json:"role_name"`
+}
+
+// canInstruct reports whether the user has admin or maintain permission on
+// the repository.
+func canInstruct(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%v/%v/collaborators/%v/permission", owner, repo, login), nil)
+	if err != nil {
+		return false, err
+	}
+	var level permissionLevel
+	if _, err := client.Do(ctx, req, &level); err != nil {
+		return false, errors.Wrapf(err, "failed to get permission level of %s", login)
+	}
+	role := level.RoleName
+	if role == "" {
+		role = level.Permission
+	}
+	switch role {
+	case "admin", "maintain":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// jump moves the trainee to the given step and posts its instructions, which
+// are the actions of the step before it.
+func (e *Engine) jump(ctx context.Context, client *github.Client, p *progress.Progress, step course.Step) error {
+	p.Advance(step.ID)
+	if err := e.Progress.Save(ctx, p); err != nil {
+		return err
+	}
+
+	i := e.Course.Index(step.ID)
+	if i == 0 {
+		return nil
+	}
+	return e.replay(ctx, client, e.Course.Steps[i-1], e.progressVars(p), p)
+}
+
+func (e *Engine) stepIDs() string {
+	var ids []string
+	for _, step := range e.Course.Steps {
+		ids = append(ids, "`"+step.ID+"`")
+	}
+	return strings.Join(ids, ", ")
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/go-github/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+type IssueCommentHandler struct {
+	*Engine
+}
+
+func (h *IssueCommentHandler) Handles() []string {
+	return []string{"issue_comment"}
+}
+
+func (h *IssueCommentHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.IssueCommentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errors.Wrap(err, "failed to parse issue_comment event payload")
+	}
+
+	logrus.Infof("Handling %s", event.GetAction())
+
+	if event.GetAction() != "created" {
+		return nil
+	}
+	if event.GetSender().GetType() == "Bot" {
+		logrus.Infof("Dropping issue_comment event because it was posted by a bot")
+		return nil
+	}
+
+	cmd, ok := ParseCommand(event.GetComment().GetBody())
+	if !ok {
+		return nil
+	}
+
+	repo := event.GetRepo()
+	if err := h.Command(ctx, Event{
+		Type:           eventType,
+		Action:         event.GetAction(),
+		DeliveryID:     deliveryID,
+		InstallationID: githubapp.GetInstallationIDFromEvent(&event),
+		Owner:          repo.GetOwner().GetLogin(),
+		Repo:           repo.GetName(),
+		DefaultBranch:  repo.GetDefaultBranch(),
+		Trainee:        event.GetSender().GetLogin(),
+		Issue:          event.GetIssue(),
+	}, cmd); err != nil {
+		return errors.Wrapf(err, "failed to handle /%s", cmd.Name)
+	}
+
+	return nil
+}
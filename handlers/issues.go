@@ -3,7 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -13,7 +13,7 @@ import (
 )
 
 type IssuesHandler struct {
-	githubapp.ClientCreator
+	*Engine
 }
 
 func (h *IssuesHandler) Handles() []string {
@@ -26,139 +26,28 @@ func (h *IssuesHandler) Handle(ctx context.Context, eventType, deliveryID string
 		return errors.Wrap(err, "failed to parse issue event payload")
 	}
 
-	switch event.GetAction() {
-	case "opened":
-		logrus.Infof("Handling %s", event.GetAction())
-		if err := h.opened(ctx, event); err != nil {
-			return errors.Wrap(err, "failed to parse issue open")
-		}
-		break
-	case "assigned":
-		logrus.Infof("Handling %s", event.GetAction())
-		if err := h.assigned(ctx, event); err != nil {
-			return errors.Wrap(err, "failed to parse issue open")
-		}
-		break
-	default:
-		logrus.Infof("Handling %s", event.GetAction())
-	}
-
-	return nil
-}
-
-func (h *IssuesHandler) opened(ctx context.Context, event github.IssuesEvent) error {
-	installationID := githubapp.GetInstallationIDFromEvent(&event)
-	client, err := h.NewInstallationClient(installationID)
-	if err != nil {
-		return err
-	}
+	logrus.Infof("Handling %s", event.GetAction())
 
 	repo := event.GetRepo()
-	issueNumber := event.GetIssue().GetNumber()
-	repoOwner := repo.GetOwner().GetLogin()
-	repoName := repo.GetName()
-	author := event.GetIssue().GetUser()
-	comment := github.IssueComment{
-		Body: String(fmt.Sprintf(`# :wave: Welcome to GitHub Training, @%s!
-
-I’ll guide you through some important first steps in coding and collaborating on GitHub.
-
-This is an issue <sup>[:book:](https://help.github.com/articles/github-glossary/#issue)</sup>: a place to record bugs, request enhancements, or answer questions about your repo.
-
-<hr>
-<h3 align="center">Keep reading below to find your first task</h3>`, author.GetLogin())),
-	}
-	if _, _, err := client.Issues.CreateComment(ctx, repoOwner, repoName, issueNumber, &comment); err != nil {
-		logrus.WithError(err).Error("Failed to create issue comment")
-	}
-
-	comment = github.IssueComment{
-		Body: String(fmt.Sprintf(`## Step 1: Assign yourself
-
-Unassigned issues don't have owners to look after them. 
-
-### :keyboard: Action Requested
-
-1. On the right side of the screen, under the "Assignees" section, click the gear icon and select yourself
-		
-<hr>
-<h3 align="center">I'll respond when I detect you've assigned yourself to this issue.</h3>
-
-> If you perform an expected action and don't see a response from me, wait a few seconds and refresh the page for your next steps.`)),
-	}
-	if _, _, err := client.Issues.CreateComment(ctx, repoOwner, repoName, issueNumber, &comment); err != nil {
-		logrus.WithError(err).Error("Failed to create issue comment 2")
-	}
-
-	return nil
-}
-
-func String(s string) *string {
-	return &s
-}
-
-func (h *IssuesHandler) assigned(ctx context.Context, event github.IssuesEvent) error {
-	installationID := githubapp.GetInstallationIDFromEvent(&event)
-	client, err := h.NewInstallationClient(installationID)
-	if err != nil {
-		return err
-	}
-
-	repo := event.GetRepo()
-	issueNumber := event.GetIssue().GetNumber()
-	repoOwner := repo.GetOwner().GetLogin()
-	repoName := repo.GetName()
-	author := event.GetIssue().GetUser()
-	assignees := event.GetIssue().Assignees
-
-	isAssigned := false
-	for _, assignee := range assignees {
-		if assignee.GetLogin() == author.GetLogin() {
-			isAssigned = true
+	ev := Event{
+		Type:           eventType,
+		Action:         event.GetAction(),
+		DeliveryID:     deliveryID,
+		InstallationID: githubapp.GetInstallationIDFromEvent(&event),
+		Owner:          repo.GetOwner().GetLogin(),
+		Repo:           repo.GetName(),
+		DefaultBranch:  repo.GetDefaultBranch(),
+		Trainee:        event.GetIssue().GetUser().GetLogin(),
+		Issue:          event.GetIssue(),
+	}
+	if label := event.GetLabel().GetName(); event.GetAction() == "labeled" && strings.HasPrefix(label, LanguageLabelPrefix) {
+		if err := h.Label(ctx, ev, label); err != nil {
+			return errors.Wrapf(err, "failed to handle label %s", label)
 		}
-	}
-
-	if !isAssigned {
-		logrus.Infof("Dropping assigned event because user %s != assignees %v", author, assignees)
 		return nil
 	}
-
-	comment := github.IssueComment{
-		Body: String(fmt.Sprintf(`## Introduction to a typical workflow
-
-Now that you're familiar with issues, let's use this issue to track your path to your first contribution.
-
-People use different workflows to contribute to software projects, but the simplest and most effective way to contribute on GitHub is the GitHub flow.
-
-:tv: [Video: Understanding the GitHub flow](https://www.youtube.com/watch?v=PBI2Rz-ZOxU)
-
-<hr>
-<h3 align="center">Read below for next steps</h3>`)),
-	}
-	if _, _, err := client.Issues.CreateComment(ctx, repoOwner, repoName, issueNumber, &comment); err != nil {
-		logrus.WithError(err).Error("Failed to create issue comment")
-	}
-
-	comment = github.IssueComment{
-		Body: String(fmt.Sprintf(`## Step 2: Create a branch
-
-Let’s complete the first step of the GitHub flow: creating a branch <sup>[:book:](https://help.github.com/articles/github-glossary/#branch)</sup>.
-
-Day to day, it's more likely that you'll be creating your branch on the command line, but to keep training simple, let's create it in the web UI.
-
-### :keyboard: Action Requested: Your first branch
-
-1. Navigate to the [Code tab](https://github.com/%s/%s)
-2. Click **Branch: master** in the drop-down
-3. In the field, enter a name for your branch, like "feat/%s-1"
-4. Click **Create branch: <name>** or press the “Enter” key to create your branch
-
-
-<hr>
-<h3 align="center">I'll respond when I detect a new branch has been created in this repository.</h3>`, repoOwner, repoName, author.GetLogin())),
-	}
-	if _, _, err := client.Issues.CreateComment(ctx, repoOwner, repoName, issueNumber, &comment); err != nil {
-		logrus.WithError(err).Error("Failed to create issue comment 2")
+	if err := h.Run(ctx, ev); err != nil {
+		return errors.Wrapf(err, "failed to handle issue %s", event.GetAction())
 	}
 
 	return nil
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/content"
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+)
+
+// LanguageLabelPrefix starts the labels that pick a trainee's language, such
+// as "lang:de".
+const LanguageLabelPrefix = "lang:"
+
+// languageLine matches a "Language: de" or "lang: de" line in an issue body.
+var languageLine = regexp.MustCompile(`(?im)^[ \t]*(?:language|lang)[ \t]*:[ \t]*([a-z]{2,3}(?:[-_][a-z]{2,4})?)[ \t]*$`)
+
+// issueLanguage returns the language a training issue asks for, with a
+// "lang:" label or a line in its body, or "" if it doesn't ask for one the
+// content supports.
+func (e *Engine) issueLanguage(issue *github.Issue) string {
+	if issue == nil {
+		return ""
+	}
+	for _, label := range issue.Labels {
+		if lang := strings.TrimPrefix(label.GetName(), LanguageLabelPrefix); lang != label.GetName() && e.Content.Supports(lang) {
+			return content.Normalize(lang)
+		}
+	}
+	if m := languageLine.FindStringSubmatch(issue.GetBody()); m != nil && e.Content.Supports(m[1]) {
+		return content.Normalize(m[1])
+	}
+	return ""
+}
+
+// startLanguage picks the language of a new run of the course: the one its
+// issue asks for, or else the one the trainee used last time.
+func (e *Engine) startLanguage(event Event, previous *progress.Progress) string {
+	if lang := e.issueLanguage(event.Issue); lang != "" {
+		return lang
+	}
+	if previous != nil {
+		return previous.Lang
+	}
+	return ""
+}
+
+// Label switches the trainee's language when a "lang:" label is added to
+// their training issue, and says so in the new language. The reply carries
+// the trainee's marker, which is where progress read from markers keeps the
+// choice.
+func (e *Engine) Label(ctx context.Context, event Event, label string) error {
+	lang := strings.TrimPrefix(label, LanguageLabelPrefix)
+	if !e.Content.Supports(lang) {
+		logrus.Infof("Ignoring label %s because there is no content in %s", label, lang)
+		return nil
+	}
+
+	ctx = outbox.WithOrigin(ctx, event.DeliveryID)
+	client, err := e.NewInstallationClient(event.InstallationID)
+	if err != nil {
+		return err
+	}
+	p, err := e.Progress.Get(ctx, client, event.key())
+	if err != nil {
+		return err
+	}
+	if p == nil || p.Completed() || p.Issue != event.Issue.GetNumber() || p.Lang == content.Normalize(lang) {
+		return nil
+	}
+
+	p.Lang = content.Normalize(lang)
+	logrus.Infof("Switching %s to %s", event.Trainee, p.Lang)
+	if err := e.Progress.Save(ctx, p); err != nil {
+		return err
+	}
+	body, err := e.say(p.Lang, "lang_set", map[string]interface{}{"Trainee": event.Trainee, "Language": e.language(p.Lang)})
+	if err != nil {
+		return err
+	}
+	if err := e.reply(ctx, client, event, body+"\n\n"+e.marker(p).String()); err != nil {
+		return err
+	}
+	return e.Progress.Save(ctx, p)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/outbox"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// TrainingLabel marks the issue a trainee is taking the course in.
+const TrainingLabel = "git-training"
+
+// linkedIssue returns the number of the trainee's training issue, or 0 if it
+// can't be determined. The link recorded in their progress wins. Without one,
+// the issue that starts a course is the link, and otherwise the trainee's one
+// open training issue is used: labelled if any are, or else their only open
+// issue. When several qualify the trainee is asked to close the extras.
+func (e *Engine) linkedIssue(ctx context.Context, client *github.Client, event Event, p *progress.Progress) (int, error) {
+	if p != nil && !p.Completed() && p.Issue != 0 {
+		return p.Issue, nil
+	}
+	if (p == nil || p.Completed()) && event.Issue != nil {
+		return event.Issue.GetNumber(), nil
+	}
+
+	candidates, err := openIssues(ctx, client, event.Owner, event.Repo, event.Trainee, []string{TrainingLabel})
+	if err != nil {
+		return 0, err
+	}
+	if len(candidates) == 0 {
+		if candidates, err = openIssues(ctx, client, event.Owner, event.Repo, event.Trainee, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		logrus.Infof("Dropping %s event because %s has no open training issue", event.Type, event.Trainee)
+		return 0, nil
+	case 1:
+		return candidates[0].GetNumber(), nil
+	default:
+		logrus.Infof("Dropping %s event because %s has %d open training issues", event.Type, event.Trainee, len(candidates))
+		run, lang := 1, ""
+		if p != nil {
+			run, lang = p.Run, p.Lang
+			if p.Completed() {
+				run++
+			}
+		}
+		return 0, e.reportAmbiguousIssues(ctx, client, event, run, lang, candidates)
+	}
+}
+
+// openIssues lists the open issues, excluding pull requests, created by the
+// trainee with the given labels.
+func openIssues(ctx context.Context, client *github.Client, owner, repo, login string, labels []string) ([]*github.Issue, error) {
+	opts := &github.IssueListByRepoOptions{
+		Creator:     login,
+		Labels:      labels,
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var open []*github.Issue
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list issues created by %s", login)
+		}
+		for _, issue := range issues {
+			if !issue.IsPullRequest() {
+				open = append(open, issue)
+			}
+		}
+		if resp.NextPage == 0 {
+			return open, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (e *Engine) labelIssue(ctx context.Context, client *github.Client, installationID int64, owner, repo string, number int) error {
+	return e.post(ctx, client, outbox.Labels(installationID, owner, repo, number, TrainingLabel))
+}
+
+// reportAmbiguousIssues asks the trainee, in lang, to close their extra
+// training issues. Each issue is told once per run: the comment hides a note
+// naming the run, and issues that already have one, or have one queued, are
+// skipped.
+func (e *Engine) reportAmbiguousIssues(ctx context.Context, client *github.Client, event Event, run int, lang string, issues []*github.Issue) error {
+	note := fmt.Sprintf("<!-- git-training ambiguous trainee=%s run=%d -->", event.Trainee, run)
+	var refs []string
+	for _, issue := range issues {
+		refs = append(refs, fmt.Sprintf("#%d", issue.GetNumber()))
+	}
+
+	body, err := e.say(lang, "ambiguous_issues", map[string]interface{}{"Trainee": event.Trainee, "Issues": strings.Join(refs, ", ")})
+	if err != nil {
+		return err
+	}
+	body += "\n\n" + note
+	for _, issue := range issues {
+		reported, err := hasBotComment(ctx, client, event.Owner, event.Repo, issue.GetNumber(), note)
+		if err != nil {
+			return err
+		}
+		if reported {
+			continue
+		}
+		// The ID stops the outbox queueing it again while it's undelivered.
+		m := outbox.Comment(event.InstallationID, event.Owner, event.Repo, issue.GetNumber(), body)
+		m.ID = fmt.Sprintf("ambiguous/%s/%d/%d", event.key(), run, issue.GetNumber())
+		if err := e.post(ctx, client, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasBotComment reports whether the bot has commented on the issue with a
+// body containing text.
+func hasBotComment(ctx context.Context, client *github.Client, owner, repo string, number int, text string) (bool, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to list comments on #%d", number)
+		}
+		for _, c := range comments {
+			if c.GetUser().GetType() == "Bot" && strings.Contains(c.GetBody(), text) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
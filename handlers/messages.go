@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/content"
+	"github.com/fanatic/git-training/course"
+	"github.com/pkg/errors"
+)
+
+// messages are the catalog keys the handlers post, and the fields each one
+// is rendered with.
+var messages = map[string][]string{
+	"language_name":               nil,
+	"not_taking_course":           {"Trainee"},
+	"not_started":                 {"Trainee"},
+	"paused":                      {"Trainee"},
+	"not_optional":                {"Trainee", "Step"},
+	"no_hints":                    {"Trainee", "Step"},
+	"hint":                        {"N", "Total", "Step", "Text", "More"},
+	"status":                      {"Trainee", "Course", "Started", "Steps", "Since", "Attempts", "Hints"},
+	"help":                        {"Trainee"},
+	"lang_set":                    {"Trainee", "Language"},
+	"lang_current":                {"Trainee", "Language", "Available"},
+	"lang_unsupported":            {"Trainee", "Lang", "Available"},
+	"instructor_denied":           {"Trainee", "Command"},
+	"instructor_not_started":      {"Target"},
+	"instructor_already_complete": {"Target"},
+	"instructor_advanced":         {"Target", "Step"},
+	"instructor_reset":            {"Target"},
+	"instructor_unknown_step":     {"Target", "Steps"},
+	"instructor_moved":            {"Target", "Step"},
+	"instructor_paused":           {"Target"},
+	"instructor_resumed":          {"Target"},
+	"ambiguous_issues":            {"Trainee", "Issues"},
+	"review_comment_outside_diff": {"Path", "Line", "Body"},
+}
+
+// CheckMessages makes sure the default catalog has every message the
+// handlers post, and that every catalog's messages parse and use only the
+// fields they are rendered with.
+func CheckMessages(c *content.Content) error {
+	for key := range messages {
+		if _, ok := c.Message(course.DefaultLocale, key); !ok {
+			return errors.Errorf("default catalog has no message %s", key)
+		}
+	}
+	for _, locale := range c.Locales() {
+		for key, fields := range messages {
+			text, ok := c.Message(locale, key)
+			if !ok {
+				continue
+			}
+			t, err := parseMessage(text)
+			if err != nil {
+				return errors.Wrapf(err, "%s message %s", locale, key)
+			}
+			for _, field := range course.Fields(t) {
+				if !contains(fields, field) {
+					return errors.Errorf("%s message %s uses .%s, which it isn't rendered with", locale, key, field)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// say renders a catalog message in the trainee's language. A message that is
+// missing, fails to render or renders blank falls back along the language's
+// chain, ending with the default.
+func (e *Engine) say(lang, key string, data map[string]interface{}) (string, error) {
+	var err error
+	for _, locale := range content.Chain(lang) {
+		text, ok := e.Content.Message(locale, key)
+		if !ok {
+			continue
+		}
+		var out string
+		out, err = renderMessage(text, data)
+		if err == nil && strings.TrimSpace(out) != "" {
+			return out, nil
+		}
+		logrus.Warnf("Falling back from %s message %s: %v", locale, key, err)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render message %s", key)
+	}
+	return "", errors.Errorf("no message %s", key)
+}
+
+// language returns the name of the language the trainee's messages are in.
+func (e *Engine) language(lang string) string {
+	name, err := e.say(lang, "language_name", nil)
+	if err != nil {
+		return lang
+	}
+	return name
+}
+
+func parseMessage(text string) (*template.Template, error) {
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse message")
+	}
+	return t, nil
+}
+
+func renderMessage(text string, data map[string]interface{}) (string, error) {
+	t, err := parseMessage(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, "failed to render message")
+	}
+	return buf.String(), nil
+}
@@ -3,7 +3,6 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/sirupsen/logrus"
 
@@ -13,7 +12,7 @@ import (
 )
 
 type PushHandler struct {
-	githubapp.ClientCreator
+	*Engine
 }
 
 func (h *PushHandler) Handles() []string {
@@ -32,87 +31,45 @@ func (h *PushHandler) Handle(ctx context.Context, eventType, deliveryID string,
 		logrus.Infof("Dropping push event because it was a create or delete")
 		return nil
 	}
-	if event.GetRef() == "refs/heads/master" {
-		logrus.Infof("Dropping push event because it was for master")
+	repo := event.GetRepo()
+	defaultBranch := repo.GetDefaultBranch()
+	if defaultBranch == "" {
+		defaultBranch = repo.GetMasterBranch()
+	}
+	if event.GetRef() == "refs/heads/"+defaultBranch {
+		logrus.Infof("Dropping push event because it was for the default branch, %s", defaultBranch)
 		return nil
 	}
-	if err := h.commitCreated(ctx, event); err != nil {
-		return errors.Wrap(err, "failed to parse push")
+
+	if err := h.Run(ctx, Event{
+		Type:           eventType,
+		DeliveryID:     deliveryID,
+		InstallationID: githubapp.GetInstallationIDFromEvent(&event),
+		Owner:          repo.GetOwner().GetName(),
+		Repo:           repo.GetName(),
+		DefaultBranch:  defaultBranch,
+		Trainee:        event.GetSender().GetLogin(),
+		Branch:         event.GetRef(),
+		SHA:            event.GetAfter(),
+		Files:          changedFiles(event),
+	}); err != nil {
+		return errors.Wrap(err, "failed to handle push")
 	}
 
 	return nil
 }
 
-func (h *PushHandler) commitCreated(ctx context.Context, event github.PushEvent) error {
-	installationID := githubapp.GetInstallationIDFromEvent(&event)
-	client, err := h.NewInstallationClient(installationID)
-	if err != nil {
-		return err
+// changedFiles lists the files the pushed commits added or modified.
+func changedFiles(event github.PushEvent) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, c := range event.Commits {
+		for _, f := range append(append([]string(nil), c.Added...), c.Modified...) {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
 	}
-
-	repo := event.GetRepo()
-	repoOwner := repo.GetOwner().GetName()
-	repoName := repo.GetName()
-	author := event.GetSender()
-	branchName := event.GetRef()
-
-	issueNumber, err := FindIssueNumberByAssignee(ctx, client, repoOwner, repoName, author.GetLogin())
-	if err != nil {
-		return err
-	} else if issueNumber == 0 {
-		return nil
-	}
-
-	// Hard to correct the user in the first case - we expect them to edit the branch later in the PR, and this incorrectly fires
-
-	// expectedFilename := "users/" + author.GetLogin() + ".md"
-
-	// hasExpectedFile := false
-	// for _, filename := range event.GetHeadCommit().Added {
-	// 	if filename == expectedFilename {
-	// 		hasExpectedFile = true
-	// 	}
-	// }
-
-	// 	if !hasExpectedFile {
-	// 		comment := github.IssueComment{
-	// 			Body: String(fmt.Sprintf(`## Something's not quite right.
-
-	// I'm looking for a new file named "users/%s.md" in your branch %s.`, author.GetLogin(), branchName)),
-	// 		}
-	// 		if _, _, err := client.Issues.CreateComment(ctx, repoOwner, repoName, issueNumber, &comment); err != nil {
-	// 			logrus.WithError(err).Error("Failed to create issue comment")
-	// 		}
-
-	// 		return nil
-	// 	}
-
-	comment := github.IssueComment{
-		Body: String(fmt.Sprintf(`## Step 4: Open a pull request
-
-Nice work making that commit :sparkles:
-
-In the real world, that commit would contain code working towards some feature or bug fix for one of our products.  Since we're just training here, it can contain anything.
-
-Now that you’ve created a commit, it’s time to share your proposed change through a pull request! Where issues encourage discussion with other contributors and collaborators on a project, pull requests help you share your changes, receive feedback on them, and iterate on them until they’re perfect!
-
-### :keyboard: Action Requested: Create a pull request
-
-1. Open a pull request:
-		- From the "Pull requests" tab, click **New pull request**
-		- In the "base:" drop-down menu, make sure the "master" branch is selected
-		- In the "compare:" drop-down menu, select "%s"
-1. When you’ve selected your branch, enter a title for your pull request. For example "Add %s's file"
-1. The next field helps you provide a description of the changes you made. Feel free to add a description of what you’ve accomplished so far. As a reminder, you have: created a branch, created a file and made a commit, and opened a pull request
-1. Click **Create pull request**
-
-<hr>
-<h3 align="center">I'll respond in your new pull request.</h3>
-		`, branchName, author.GetLogin())),
-	}
-	if _, _, err := client.Issues.CreateComment(ctx, repoOwner, repoName, issueNumber, &comment); err != nil {
-		logrus.WithError(err).Error("Failed to create issue comment")
-	}
-
-	return nil
+	return files
 }
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/course"
+	"github.com/fanatic/git-training/progress"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// Reconciliation is the outcome of reconciling one trainee.
+type Reconciliation struct {
+	Repo    string `json:"repo"`
+	Trainee string `json:"trainee"`
+	Issue   int    `json:"issue"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Posted  bool   `json:"posted"`
+	Note    string `json:"note,omitempty"`
+}
+
+// evidence is what the repository shows a trainee has done in their current
+// run: their training issue, and the branch and pull request made since it
+// was opened.
+type evidence struct {
+	issue       *github.Issue
+	branch      string
+	aheadBy     int
+	pullRequest *github.PullRequest
+}
+
+// ReconcileAll reconciles every repository of every installation of the app.
+func (e *Engine) ReconcileAll(ctx context.Context) ([]Reconciliation, error) {
+	appClient, err := e.NewAppClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var installations []*github.Installation
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := appClient.Apps.ListInstallations(ctx, opt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list installations")
+		}
+		installations = append(installations, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	var results []Reconciliation
+	for _, installation := range installations {
+		client, err := e.NewInstallationClient(installation.GetID())
+		if err != nil {
+			return results, err
+		}
+
+		opt := &github.ListOptions{PerPage: 100}
+		for {
+			repos, resp, err := client.Apps.ListRepos(ctx, opt)
+			if err != nil {
+				return results, errors.Wrapf(err, "failed to list repositories of installation %d", installation.GetID())
+			}
+			for _, repo := range repos {
+				r, err := e.Reconcile(ctx, installation.GetID(), repo.GetOwner().GetLogin(), repo.GetName())
+				results = append(results, r...)
+				if err != nil {
+					return results, err
+				}
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	return results, nil
+}
+
+// Reconcile works out from the state of a repository which step each trainee
+// with an open issue in it has really reached, and brings their progress up
+// to date. When the instructions for that step are missing, because webhooks
+// were missed or a comment failed to post, it posts them.
+func (e *Engine) Reconcile(ctx context.Context, installationID int64, owner, repo string) ([]Reconciliation, error) {
+	client, err := e.NewInstallationClient(installationID)
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := listOpenIssues(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	byTrainee := map[string][]*github.Issue{}
+	var trainees []string
+	for _, issue := range issues {
+		login := issue.GetUser().GetLogin()
+		if issue.GetUser().GetType() == "Bot" {
+			continue
+		}
+		if byTrainee[login] == nil {
+			trainees = append(trainees, login)
+		}
+		byTrainee[login] = append(byTrainee[login], issue)
+	}
+
+	var results []Reconciliation
+	for _, login := range trainees {
+		key := progress.Key{InstallationID: installationID, Owner: owner, Repo: repo, Login: login}
+		r, err := e.reconcileTrainee(ctx, client, key, byTrainee[login])
+		if err != nil {
+			return results, errors.Wrapf(err, "failed to reconcile %s", key)
+		}
+		logrus.Infof("Reconciled %s: %s -> %s (posted: %t) %s", key, r.From, r.To, r.Posted, r.Note)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (e *Engine) reconcileTrainee(ctx context.Context, client *github.Client, key progress.Key, issues []*github.Issue) (Reconciliation, error) {
+	r := Reconciliation{Repo: key.Owner + "/" + key.Repo, Trainee: key.Login}
+
+	p, err := e.Progress.Get(ctx, client, key)
+	if err != nil {
+		return r, err
+	}
+	if p != nil {
+		r.From = p.Step
+	}
+
+	issue := issues[0]
+	if len(issues) > 1 {
+		issue = nil
+		for _, i := range issues {
+			if p != nil && i.GetNumber() == p.Issue {
+				issue = i
+			}
+		}
+		if issue == nil {
+			r.Note = "several open issues and none is linked"
+			return r, nil
+		}
+	}
+	r.Issue = issue.GetNumber()
+
+	ev, err := e.gatherEvidence(ctx, client, key, issue)
+	if err != nil {
+		return r, err
+	}
+
+	reached := 0
+	for reached < len(e.Course.Steps) && e.satisfied(ctx, client, e.Course.Steps[reached], key, ev) {
+		reached++
+	}
+	if reached == 0 {
+		r.Note = "nothing to do yet"
+		return r, nil
+	}
+	target := ""
+	if reached < len(e.Course.Steps) {
+		target = e.Course.Steps[reached].ID
+	}
+	r.To = target
+
+	if p == nil || p.Completed() || p.Issue != 0 && p.Issue != issue.GetNumber() {
+		run, posts := 1, 0
+		if p != nil {
+			run, posts = p.Run+1, p.Posts
+		}
+		p = progress.New(key, e.Course.ID, e.Course.Steps[0].ID)
+		p.Run = run
+		p.Posts = posts
+		p.Issue = issue.GetNumber()
+		if err := e.labelIssue(ctx, client, key.InstallationID, key.Owner, key.Repo, p.Issue); err != nil {
+			return r, err
+		}
+	} else if e.Course.Index(p.Step) > reached {
+		r.To = p.Step
+		r.Note = "progress is ahead of the repository; left alone"
+		return r, nil
+	}
+
+	posted, err := progress.Markers{}.Get(ctx, client, key)
+	if err != nil {
+		return r, err
+	}
+	missing := posted == nil || posted.Run != 0 && posted.Run != p.Run || posted.Step != target
+	if posted != nil && posted.Posts > p.Posts {
+		p.Posts = posted.Posts
+	}
+
+	if p.Step != target || p.Issue == 0 {
+		p.Advance(target)
+		p.Issue = issue.GetNumber()
+		p.Branch = ev.branch
+		p.PullRequest = ev.pullRequest.GetNumber()
+		if err := e.Progress.Save(ctx, p); err != nil {
+			return r, err
+		}
+	}
+	if !missing {
+		return r, nil
+	}
+
+	vars := e.withLinks(course.Vars{
+		Trainee:     key.Login,
+		Owner:       key.Owner,
+		Repo:        key.Repo,
+		Branch:      ev.branch,
+		IssueNumber: issue.GetNumber(),
+	})
+	if ev.pullRequest != nil {
+		vars.PRNumber = ev.pullRequest.GetNumber()
+	}
+	if err := e.replay(ctx, client, e.Course.Steps[reached-1], vars, p); err != nil {
+		return r, err
+	}
+	r.Posted = true
+	return r, nil
+}
+
+// satisfied reports whether the evidence shows the step was completed: there
+// is something in the repository that would have triggered it, and the step's
+// validations pass against it.
+func (e *Engine) satisfied(ctx context.Context, client *github.Client, step course.Step, key progress.Key, ev evidence) bool {
+	event := Event{
+		Type:           step.On.Event,
+		InstallationID: key.InstallationID,
+		Owner:          key.Owner,
+		Repo:           key.Repo,
+		Trainee:        key.Login,
+		Branch:         ev.branch,
+		Issue:          ev.issue,
+		PullRequest:    ev.pullRequest,
+	}
+	if len(step.On.Actions) > 0 {
+		event.Action = step.On.Actions[0]
+	}
+
+	pr := ev.pullRequest
+	switch step.On.Event {
+	case "issues":
+		switch event.Action {
+		case "opened", "reopened", "":
+		case "assigned":
+			if len(ev.issue.Assignees) == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	case "create":
+		if ev.branch == "" {
+			return false
+		}
+	case "push":
+		if ev.branch == "" || ev.aheadBy == 0 {
+			return false
+		}
+	case "pull_request":
+		if pr == nil {
+			return false
+		}
+		switch event.Action {
+		case "opened", "reopened", "edited", "":
+		case "synchronize":
+			if pr.GetCommits() < 2 {
+				return false
+			}
+		case "closed":
+			if pr.GetState() != "closed" {
+				return false
+			}
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+
+	failed, err := e.validate(ctx, client, step, event, e.withLinks(course.Vars{
+		Trainee:     key.Login,
+		Owner:       key.Owner,
+		Repo:        key.Repo,
+		Branch:      ev.branch,
+		IssueNumber: ev.issue.GetNumber(),
+		PRNumber:    pr.GetNumber(),
+	}))
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to validate step %s for %s", step.ID, key)
+		return false
+	}
+	return failed == nil
+}
+
+func (e *Engine) gatherEvidence(ctx context.Context, client *github.Client, key progress.Key, issue *github.Issue) (evidence, error) {
+	ev := evidence{issue: issue}
+
+	var pulls []*github.PullRequest
+	opt := &github.PullRequestListOptions{State: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := client.PullRequests.List(ctx, key.Owner, key.Repo, opt)
+		if err != nil {
+			return ev, errors.Wrap(err, "failed to list pull requests")
+		}
+		pulls = append(pulls, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	for _, pr := range pulls {
+		if pr.GetUser().GetLogin() != key.Login || pr.GetCreatedAt().Before(issue.GetCreatedAt()) {
+			continue
+		}
+		if ev.pullRequest == nil || pr.GetState() == "open" && ev.pullRequest.GetState() != "open" {
+			ev.pullRequest = pr
+		}
+	}
+	if ev.pullRequest != nil {
+		// The list omits the commit count.
+		pr, _, err := client.PullRequests.Get(ctx, key.Owner, key.Repo, ev.pullRequest.GetNumber())
+		if err != nil {
+			return ev, errors.Wrapf(err, "failed to get pull request #%d", ev.pullRequest.GetNumber())
+		}
+		ev.pullRequest = pr
+		ev.branch = pr.GetHead().GetRef()
+		ev.aheadBy = pr.GetCommits()
+		return ev, nil
+	}
+
+	repo, _, err := client.Repositories.Get(ctx, key.Owner, key.Repo)
+	if err != nil {
+		return ev, errors.Wrap(err, "failed to get repository")
+	}
+	branches, _, err := client.Repositories.ListBranches(ctx, key.Owner, key.Repo, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return ev, errors.Wrap(err, "failed to list branches")
+	}
+	for _, branch := range branches {
+		if branch.GetName() == repo.GetDefaultBranch() {
+			continue
+		}
+		comparison, _, err := client.Repositories.CompareCommits(ctx, key.Owner, key.Repo, repo.GetDefaultBranch(), branch.GetName())
+		if err != nil {
+			return ev, errors.Wrapf(err, "failed to compare %s", branch.GetName())
+		}
+
+		authored := 0
+		for _, commit := range comparison.Commits {
+			if commit.GetAuthor().GetLogin() == key.Login {
+				authored++
+			}
+		}
+		named := strings.Contains(strings.ToLower(branch.GetName()), strings.ToLower(key.Login))
+		if authored > ev.aheadBy || authored == 0 && named && ev.branch == "" {
+			ev.branch = branch.GetName()
+			ev.aheadBy = authored
+		}
+	}
+	return ev, nil
+}
+
+func listOpenIssues(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Issue, error) {
+	var issues []*github.Issue
+	opt := &github.IssueListByRepoOptions{State: "open", Direction: "asc", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := client.Issues.ListByRepo(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list issues")
+		}
+		for _, issue := range page {
+			if !issue.IsPullRequest() {
+				issues = append(issues, issue)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return issues, nil
+}
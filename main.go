@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
-	"github.com/ctrlaltdel121/configor"
-	"github.com/fanatic/git-training/handlers"
+	"github.com/fanatic/git-training/dryrun"
+	"github.com/fanatic/git-training/store"
 	"github.com/gregjones/httpcache"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/rs/zerolog"
@@ -14,43 +17,126 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-type Config struct {
-	Github githubapp.Config `yaml:"github"`
-}
+// dryRunJournalSize is how many of the writes held back in dry-run mode the
+// admin endpoint shows.
+const dryRunJournalSize = 1000
 
 func main() {
-	var cfg Config
-	if err := configor.Load(&cfg, "config.yml"); err != nil {
+	cfg, problems, err := loadConfig(os.Getenv, "config.yml")
+	if err != nil {
 		logrus.Fatalf("Error loading config: %s\n", err)
 	}
 
-	cfg.Github.App.IntegrationID, _ = strconv.Atoi(os.Getenv("INTEGRATION_ID"))
-	cfg.Github.App.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
-	cfg.Github.OAuth.ClientID = os.Getenv("GITHUB_CLIENT_ID")
-	cfg.Github.OAuth.ClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
-	cfg.Github.App.PrivateKey = os.Getenv("GITHUB_PRIVATE_KEY")
+	// replay talks to a fake GitHub with its own storage, so it needs neither
+	// credentials nor the configured store.
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(cfg, os.Args[2:])
+		return
+	}
+
+	if problems = append(problems, cfg.problems()...); len(problems) > 0 {
+		for _, problem := range problems {
+			logrus.Error(problem)
+		}
+		logrus.Fatalf("Found %d problem(s) with the config\n", len(problems))
+	}
+
+	// record serves webhooks as usual, recording them to the given directory.
+	command := os.Args[1:]
+	if len(command) > 0 && command[0] == "record" {
+		if len(command) != 2 {
+			logrus.Fatalf("Usage: git-training record <directory>\n")
+		}
+		cfg.Webhooks.RecordDir = command[1]
+		command = nil
+	}
 
-	cc, err := githubapp.NewDefaultCachingClientCreator(
-		cfg.Github,
+	opts := []githubapp.ClientOption{
 		githubapp.WithClientUserAgent("git-training/0.0.1"),
 		githubapp.WithClientCaching(false, func() httpcache.Cache { return httpcache.NewMemoryCache() }),
-	)
+	}
+	var journal *dryrun.Journal
+	if cfg.DryRun {
+		logrus.Warn("Dry run: writes to GitHub are logged instead of sent")
+		journal = dryrun.NewJournal(dryRunJournalSize)
+		opts = append(opts, githubapp.WithClientMiddleware(journal.Middleware))
+	}
+	cc, err := githubapp.NewDefaultCachingClientCreator(cfg.Github, opts...)
 	if err != nil {
 		logrus.Fatalf("Error creating client creator: %s\n", err)
 	}
+	if err := checkApp(context.Background(), cc, cfg.Github); err != nil {
+		logrus.Fatalf("Error checking GitHub credentials: %s\n", err)
+	}
+
+	st, err := store.Open(cfg.Storage)
+	if err != nil {
+		logrus.Fatalf("Error opening storage: %s\n", err)
+	}
+	defer st.Close()
 
-	webhookHandler := githubapp.NewDefaultEventDispatcher(
-		cfg.Github,
-		&handlers.IssuesHandler{ClientCreator: cc},
-		&handlers.CreateHandler{ClientCreator: cc},
-		&handlers.PushHandler{ClientCreator: cc},
-		&handlers.PullRequestHandler{ClientCreator: cc},
-	)
+	a, err := newApp(cfg, cc, st, journal)
+	if err != nil {
+		logrus.Fatalf("Error loading app: %s\n", err)
+	}
+	engine := a.engine
+
+	if len(command) > 0 {
+		switch command[0] {
+		case "reconcile":
+			reconcile(engine, command[1:])
+			return
+		default:
+			logrus.Fatalf("Unknown command %q\n", command[0])
+		}
+	}
+
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	outboxDone := make(chan struct{})
+	go func() {
+		a.outbox.Run(outboxCtx)
+		close(outboxDone)
+	}()
+
+	if cfg.ReconcileOnStartup {
+		go func() {
+			if _, err := engine.ReconcileAll(context.Background()); err != nil {
+				logrus.WithError(err).Error("Failed to reconcile on startup")
+			}
+		}()
+	}
 
 	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
-	loggingHandler := hlog.NewHandler(logger)(webhookHandler)
+	loggingHandler := hlog.NewHandler(logger)(a.handler)
 
-	if err := http.ListenAndServe(":"+os.Getenv("PORT"), loggingHandler); err != nil {
-		logrus.Fatalf("Error creating client creator: %s\n", err)
+	server := &http.Server{Addr: ":" + strconv.Itoa(cfg.Port), Handler: loggingHandler}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Fatalf("Error creating client creator: %s\n", err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM, stop taking webhooks, finish the queued ones and
+	// deliver what they wrote to the outbox before exiting.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	logrus.Info("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Webhooks.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("Failed to shut down server")
+	}
+	if err := a.queue.Drain(ctx); err != nil {
+		logrus.WithError(err).Error("Failed to drain webhook queue")
+	}
+	if a.recorder != nil {
+		a.recorder.Wait()
+	}
+	stopOutbox()
+	<-outboxDone
+	if err := a.outbox.Flush(ctx); err != nil {
+		logrus.WithError(err).Error("Failed to deliver outbox")
 	}
 }
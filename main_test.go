@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ctrlaltdel121/configor"
+	"github.com/fanatic/git-training/dryrun"
+	"github.com/fanatic/git-training/githubtest"
+	"github.com/fanatic/git-training/progress"
+	"github.com/fanatic/git-training/store"
+)
+
+const (
+	testSecret = "secret"
+	testRepo   = "training/hello-world"
+)
+
+// newTestApp starts the app against a fake GitHub with one repository, and
+// returns a simulator wired to its webhook handler. Close the server when
+// done.
+func newTestApp(t *testing.T, configure ...func(*Config)) (*githubtest.Server, *githubtest.Simulator) {
+	gh, sim, _ := startTestApp(t, configure...)
+	return gh, sim
+}
+
+// startTestApp is newTestApp, also returning the app.
+func startTestApp(t *testing.T, configure ...func(*Config)) (*githubtest.Server, *githubtest.Simulator, *app) {
+	var cfg Config
+	if err := configor.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Github.App.WebhookSecret = testSecret
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	gh := githubtest.NewServer()
+	gh.AddRepo(1, "training", "hello-world")
+
+	st := store.NewMemory()
+	var (
+		cc      = gh.ClientCreator()
+		journal *dryrun.Journal
+	)
+	if cfg.DryRun {
+		journal = dryrun.NewJournal(dryRunJournalSize)
+		cc = gh.ClientCreator(journal.Middleware)
+	}
+	a, err := newApp(cfg, cc, st, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := githubtest.NewSimulator(t, gh, a.handler, testSecret)
+	sim.Settle = func() {
+		if err := a.settle(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gh, sim, a
+}
+
+type post struct {
+	kind   string
+	number int
+	event  string
+	title  string
+}
+
+// summarize reduces the app's posts to their kind, thread and first line,
+// checking that each carries a progress marker.
+func summarize(t *testing.T, posts []githubtest.Post) []post {
+	var summary []post
+	for _, p := range posts {
+		if _, ok := progress.ParseMarker(p.Body); !ok {
+			t.Errorf("%s on #%d has no progress marker: %q", p.Kind, p.Number, p.Body)
+		}
+		summary = append(summary, post{p.Kind, p.Number, p.Event, strings.SplitN(p.Body, "\n", 2)[0]})
+	}
+	return summary
+}
+
+func TestIntroCourse(t *testing.T) {
+	gh, sim := newTestApp(t)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	issue := octocat.OpenIssue("Training", "")
+	octocat.Assign(issue, "octocat")
+	octocat.CreateBranch("feat/octocat-1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+	pr := octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "")
+	octocat.EditPullRequest(pr, "Resolves #1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Talk is cheap. Show me the code.\n", "Update octocat.md")
+	octocat.MergePullRequest(pr)
+
+	want := []post{
+		{"comment", 1, "", "# :wave: Welcome to GitHub Training, @octocat!"},
+		{"comment", 1, "", "## Step 1: Assign yourself"},
+		{"comment", 1, "", "## Introduction to a typical workflow"},
+		{"comment", 1, "", "## Step 2: Create a branch"},
+		{"comment", 1, "", "## Step 3: Commit a file"},
+		{"comment", 1, "", "## Step 4: Open a pull request"},
+		{"comment", 2, "", "## Step 5: Link a Pull Request to an Issue"},
+		{"review", 2, "REQUEST_CHANGES", "## Step 6: Respond to a review"},
+		{"review", 2, "APPROVE", "## Step 7: Merge your pull request"},
+		{"comment", 2, "", "## Nice work"},
+	}
+	posts := gh.Posts()
+	got := summarize(t, posts)
+	if len(got) != len(want) {
+		t.Fatalf("got %d posts, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("post %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	review := posts[7]
+	if len(review.Comments) != 1 || review.Comments[0].GetPath() != "users/octocat.md" || review.Comments[0].GetPosition() != 1 {
+		t.Errorf("step 6 review comments: got %v", review.Comments)
+	}
+	if labels := gh.Repo(testRepo).Issues[0].Labels; len(labels) != 1 || labels[0] != "git-training" {
+		t.Errorf("issue labels: got %v", labels)
+	}
+}
+
+func TestRepeatedDeliveriesAreHandledOnce(t *testing.T) {
+	gh, sim := newTestApp(t)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	if n := len(gh.Posts()); n != 2 {
+		t.Fatalf("got %d posts, want 2", n)
+	}
+
+	sim.Retry()
+	sim.Redeliver()
+	if n := len(gh.Posts()); n != 2 {
+		t.Errorf("got %d posts after delivering the issue again, want 2", n)
+	}
+}
+
+func markers(cfg *Config) {
+	cfg.Progress.Source = progress.SourceMarkers
+}
+
+// onIssue returns the summaries of the posts on one issue or pull request.
+func onIssue(t *testing.T, posts []githubtest.Post, number int) []post {
+	var got []post
+	for _, p := range summarize(t, posts) {
+		if p.number == number {
+			got = append(got, p)
+		}
+	}
+	return got
+}
+
+func TestInstructorRoles(t *testing.T) {
+	gh, sim := newTestApp(t)
+	defer gh.Close()
+	gh.SetPermission(testRepo, "mona", "maintain")
+	gh.SetPermission(testRepo, "hubot", "write")
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	sim.Trainee(testRepo, "hubot").Comment(1, "/advance")
+	sim.Trainee(testRepo, "mona").Comment(1, "/advance")
+
+	posts := gh.Posts()
+	var replies []string
+	for _, p := range posts[2:] {
+		replies = append(replies, strings.SplitN(p.Body, "\n", 2)[0])
+	}
+	want := []string{
+		"@hubot, only repository admins and maintainers can use `/advance`.",
+		"## Introduction to a typical workflow",
+		"## Step 2: Create a branch",
+		"Moved @octocat on past **Assign yourself**.",
+	}
+	if strings.Join(replies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("replies to /advance: got %q, want %q", replies, want)
+	}
+}
+
+func TestPauseWithMarkers(t *testing.T) {
+	gh, sim := newTestApp(t, markers)
+	defer gh.Close()
+	gh.SetPermission(testRepo, "mona", "admin")
+	octocat := sim.Trainee(testRepo, "octocat")
+	mona := sim.Trainee(testRepo, "mona")
+
+	octocat.OpenIssue("Training", "")
+	mona.OpenIssue("Notes", "")
+	mona.Comment(2, "/pause @octocat")
+	octocat.Assign(1, "octocat")
+	mona.Comment(2, "/resume @octocat")
+	octocat.Assign(1, "octocat")
+
+	want := []post{
+		{"comment", 1, "", "# :wave: Welcome to GitHub Training, @octocat!"},
+		{"comment", 1, "", "## Step 1: Assign yourself"},
+		{"comment", 1, "", "Paused the course for @octocat. I won't move them on until an instructor says `/resume`."},
+		{"comment", 1, "", "Resumed the course for @octocat."},
+		{"comment", 1, "", "## Introduction to a typical workflow"},
+		{"comment", 1, "", "## Step 2: Create a branch"},
+	}
+	got := onIssue(t, gh.Posts(), 1)
+	if len(got) != len(want) {
+		t.Fatalf("got %d posts on #1, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("post %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestHintsWithMarkers(t *testing.T) {
+	gh, sim := newTestApp(t, markers)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	octocat.Comment(1, "/hint")
+	octocat.Comment(1, "/hint")
+
+	posts := gh.Posts()
+	if len(posts) != 4 {
+		t.Fatalf("got %d posts, want 4", len(posts))
+	}
+	for i, want := range []string{"Hint 1 of 3", "Hint 2 of 3"} {
+		if body := posts[2+i].Body; !strings.Contains(body, want) {
+			t.Errorf("reply %d to /hint: got %q, want %q", i+1, body, want)
+		}
+	}
+}
+
+func TestMarkersIncludeQueuedPosts(t *testing.T) {
+	gh, sim, a := startTestApp(t, markers)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	// Leave what the bot says next in the outbox until the end.
+	sim.Settle = a.queue.Wait
+	octocat.Assign(1, "octocat")
+	octocat.Comment(1, "/hint")
+	if err := a.outbox.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := gh.Posts()
+	if len(posts) != 5 {
+		t.Fatalf("got %d posts, want 5", len(posts))
+	}
+	if hint := posts[4].Body; !strings.Contains(hint, "Create a branch") {
+		t.Errorf("hint after assigning: got %q, want one for step 2", hint)
+	}
+}
+
+func TestMarkersPastTheFirstPage(t *testing.T) {
+	gh, sim := newTestApp(t, markers)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	for i := 0; i < 60; i++ {
+		octocat.Comment(1, "/status")
+	}
+	octocat.Assign(1, "octocat")
+	octocat.Comment(1, "/hint")
+
+	posts := gh.Posts()
+	if hint := posts[len(posts)-1].Body; !strings.Contains(hint, "Create a branch") {
+		t.Errorf("hint after assigning: got %q, want one for step 2", hint)
+	}
+}
+
+func TestMarkersInTheSameSecond(t *testing.T) {
+	gh, sim := newTestApp(t, markers)
+	defer gh.Close()
+	gh.StopClock()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	octocat.Assign(1, "octocat")
+	octocat.CreateBranch("feat/octocat-1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+	octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "")
+	octocat.Comment(1, "/hint")
+
+	posts := gh.Posts()
+	if hint := posts[len(posts)-1].Body; !strings.Contains(hint, "Link a pull request to an issue") {
+		t.Errorf("hint after opening the pull request: got %q, want one for step 5", hint)
+	}
+}
+
+func TestAmbiguousIssuesAreReportedOncePerRun(t *testing.T) {
+	gh, sim, a := startTestApp(t)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	// Progress from before runs were linked to their issue.
+	key := progress.Key{InstallationID: 1, Owner: "training", Repo: "hello-world", Login: "octocat"}
+	p := progress.New(key, "intro", "step-2")
+	p.Run = 1
+	if err := a.engine.Progress.Save(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	octocat.OpenIssue("Training", "")
+	octocat.OpenIssue("Training again", "")
+	octocat.CreateBranch("feat/octocat-1")
+	octocat.CreateBranch("feat/octocat-2")
+
+	posts := gh.Posts()
+	if len(posts) != 2 || posts[0].Number+posts[1].Number != 3 {
+		t.Fatalf("got %d posts, want one on each issue: %v", len(posts), posts)
+	}
+	if !strings.Contains(posts[0].Body, "more than one open training issue (#2, #1)") {
+		t.Errorf("got %q, want a note about the open issues", posts[0].Body)
+	}
+
+	octocat.CloseIssue(2)
+	octocat.CreateBranch("feat/octocat-3")
+	want := []post{
+		{"comment", 1, "", "## Step 3: Commit a file"},
+	}
+	if got := onIssue(t, gh.Posts()[2:], 1); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("after closing #2: got %v, want %v", got, want)
+	}
+}
+
+func TestDryRunJournalsWrites(t *testing.T) {
+	gh, sim := newTestApp(t, func(cfg *Config) {
+		cfg.DryRun = true
+		cfg.AdminToken = "admin"
+	})
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	octocat.Assign(1, "octocat")
+	if posts := gh.Posts(); len(posts) != 0 {
+		t.Errorf("got %d posts in dry-run mode, want none", len(posts))
+	}
+	if labels := gh.Repo(testRepo).Issues[0].Labels; len(labels) != 0 {
+		t.Errorf("issue labels in dry-run mode: got %v, want none", labels)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/dry-run", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	sim.Handler.ServeHTTP(w, req)
+	var journal struct {
+		Entries []dryrun.Entry `json:"entries"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&journal); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	for _, e := range journal.Entries {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []string{"labels", "comment", "comment", "comment", "comment"}
+	if strings.Join(kinds, " ") != strings.Join(want, " ") {
+		t.Errorf("journal: got %v, want %v", kinds, want)
+	}
+}
+
+func TestContentDirOverridesTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "templates", "en", "intro"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(text string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "templates", "en", "intro", "welcome.md"), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("Hi @{{.Trainee}}, welcome to {{.Repo}} on {{.DefaultBranch}}.\n")
+	gh, sim := newTestApp(t, func(cfg *Config) { cfg.ContentDir = dir })
+	defer gh.Close()
+	sim.Trainee(testRepo, "octocat").OpenIssue("Training", "")
+	if got := strings.SplitN(gh.Posts()[0].Body, "\n", 2)[0]; got != "Hi @octocat, welcome to hello-world on master." {
+		t.Errorf("welcome comment: got %q", got)
+	}
+
+	for _, text := range []string{"Hi @{{.Trainee}", "{{if .PRNumber}}#{{.PullRequest}}{{end}}"} {
+		write(text)
+		var cfg Config
+		if err := configor.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		cfg.ContentDir = dir
+		if _, err := newApp(cfg, gh.ClientCreator(), store.NewMemory(), nil); err == nil {
+			t.Errorf("loaded a course with the template %q", text)
+		}
+	}
+}
+
+func TestLanguageLabelWithMarkers(t *testing.T) {
+	gh, sim := newTestApp(t, markers)
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+
+	octocat.OpenIssue("Training", "")
+	octocat.AddLabel(1, "lang:de")
+	octocat.Comment(1, "/hint")
+
+	posts := gh.Posts()
+	if len(posts) != 4 {
+		t.Fatalf("got %d posts, want 4", len(posts))
+	}
+	if m, _ := progress.ParseMarker(posts[2].Body); m.Lang != "de" {
+		t.Errorf("reply to the label: got marker %+v, want lang de", m)
+	}
+	if hint := posts[3].Body; !strings.HasPrefix(hint, "### :bulb: Tipp 1") {
+		t.Errorf("hint after the label: got %q, want it in German", hint)
+	}
+}
+
+func TestCatalogMessagesUseTheirFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "catalogs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for text, ok := range map[string]bool{
+		`"Paused the course for @{{.Target}}."`:                  true,
+		`"Paused the course for @{{.Trainee}}."`:                 false,
+		`"{{with .Target}}Paused the course for @{{.}}.{{end}}"`: true,
+	} {
+		catalog := "messages:\n  instructor_paused: " + text + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, "catalogs", "en.yml"), []byte(catalog), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var cfg Config
+		if err := configor.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		cfg.ContentDir = dir
+		_, err := newApp(cfg, nil, store.NewMemory(), nil)
+		if ok && err != nil {
+			t.Errorf("instructor_paused %s: %v", text, err)
+		} else if !ok && err == nil {
+			t.Errorf("loaded the catalog message instructor_paused %s", text)
+		}
+	}
+}
+
+func TestEnterpriseServerLinks(t *testing.T) {
+	gh, sim := newTestApp(t, func(cfg *Config) {
+		cfg.Github.V3APIURL = "https://ghe.example.com/api/v3/"
+		cfg.Links.DocsURL = "https://ghe.example.com/help"
+		cfg.Links.Videos = map[string]string{"github_flow": "https://videos.example.com/github-flow"}
+	})
+	defer gh.Close()
+
+	octocat := sim.Trainee(testRepo, "octocat")
+	octocat.OpenIssue("Training", "")
+	octocat.Assign(1, "octocat")
+
+	var bodies []string
+	for _, p := range gh.Posts() {
+		bodies = append(bodies, p.Body)
+	}
+	all := strings.Join(bodies, "\n")
+	for _, want := range []string{
+		"(https://ghe.example.com/help/articles/github-glossary/#issue)",
+		"(https://videos.example.com/github-flow)",
+		"(https://ghe.example.com/training/hello-world)",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("no link %s in the messages", want)
+		}
+	}
+	if strings.Contains(all, "github.com/") || strings.Contains(all, "youtube.com") {
+		t.Errorf("messages still link to github.com or youtube.com:\n%s", all)
+	}
+
+	var cfg Config
+	if err := configor.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Links.Videos = map[string]string{"git_basics": "https://videos.example.com/git-basics"}
+	if _, err := newApp(cfg, gh.ClientCreator(), store.NewMemory(), nil); err == nil {
+		t.Error("loaded a link to a video the course doesn't have")
+	}
+	if err := checkApp(context.Background(), gh.ClientCreator(), cfg.Github); err != nil {
+		t.Errorf("checking the app: %s", err)
+	}
+}
+
+func TestIssueInAnotherRepoDoesNotCount(t *testing.T) {
+	gh, sim := newTestApp(t)
+	defer gh.Close()
+	gh.AddRepo(1, "training", "other")
+	octocat := sim.Trainee(testRepo, "octocat")
+	elsewhere := sim.Trainee("training/other", "octocat")
+
+	octocat.OpenIssue("Training", "")
+	elsewhere.OpenIssue("Training", "")
+	octocat.Assign(1, "octocat")
+	octocat.CreateBranch("feat/octocat-1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+	pr := octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "")
+	octocat.EditPullRequest(pr, "Fixes training/other#1")
+
+	var got []post
+	for _, p := range gh.Posts() {
+		if p.Repo == testRepo && p.Number == pr {
+			got = append(got, summarize(t, []githubtest.Post{p})...)
+		}
+	}
+	if len(got) != 2 || got[1].kind != "comment" {
+		t.Errorf("posts on #%d: got %v, want step 5 and a comment that #1 isn't linked", pr, got)
+	}
+}
@@ -0,0 +1,373 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/store"
+	"github.com/google/go-github/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+const (
+	pendingBucket = "outbox"
+	deadBucket    = "outbox_dead"
+	idsBucket     = "outbox_ids"
+)
+
+// The kinds of write the outbox delivers.
+const (
+	KindComment = "comment"
+	KindReview  = "review"
+	KindLabels  = "labels"
+)
+
+// Message is one write to GitHub. Messages to the same issue or pull request
+// are delivered in the order they were queued. ID is the message's
+// idempotency key: queueing a message with the ID of one already queued,
+// delivered or dead does nothing.
+type Message struct {
+	ID             string                           `json:"id"`
+	Seq            uint64                           `json:"seq"`
+	InstallationID int64                            `json:"installation_id"`
+	Owner          string                           `json:"owner"`
+	Repo           string                           `json:"repo"`
+	Number         int                              `json:"number"`
+	Kind           string                           `json:"kind"`
+	Body           string                           `json:"body,omitempty"`
+	Review         *github.PullRequestReviewRequest `json:"review,omitempty"`
+	Labels         []string                         `json:"labels,omitempty"`
+	Attempts       int                              `json:"attempts"`
+	LastError      string                           `json:"last_error,omitempty"`
+	CreatedAt      time.Time                        `json:"created_at"`
+	NextAttemptAt  time.Time                        `json:"next_attempt_at"`
+}
+
+// Comment returns a message that comments on an issue or pull request.
+func Comment(installationID int64, owner, repo string, number int, body string) Message {
+	return Message{InstallationID: installationID, Owner: owner, Repo: repo, Number: number, Kind: KindComment, Body: body}
+}
+
+// Review returns a message that reviews a pull request.
+func Review(installationID int64, owner, repo string, number int, review github.PullRequestReviewRequest) Message {
+	return Message{InstallationID: installationID, Owner: owner, Repo: repo, Number: number, Kind: KindReview, Review: &review}
+}
+
+// Labels returns a message that adds labels to an issue or pull request.
+func Labels(installationID int64, owner, repo string, number int, labels ...string) Message {
+	return Message{InstallationID: installationID, Owner: owner, Repo: repo, Number: number, Kind: KindLabels, Labels: labels}
+}
+
+func (m Message) thread() string {
+	return fmt.Sprintf("%d/%s/%s/%d", m.InstallationID, m.Owner, m.Repo, m.Number)
+}
+
+func (m Message) key() string {
+	return fmt.Sprintf("%s/%06d", m.CreatedAt.Format("20060102T150405.000000000"), m.Seq%1000000)
+}
+
+type Config struct {
+	MaxAttempts  int           `yaml:"max_attempts" default:"10"`
+	BaseDelay    time.Duration `yaml:"base_delay" default:"2s"`
+	MaxDelay     time.Duration `yaml:"max_delay" default:"10m"`
+	PollInterval time.Duration `yaml:"poll_interval" default:"1s"`
+	// Retention is how long the IDs of delivered messages are remembered.
+	Retention time.Duration `yaml:"retention" default:"168h"`
+}
+
+// Migrations are the versions of the outbox schema.
+var Migrations = []store.Migration{
+	{Version: 1, Description: "queued, dead and delivered messages"},
+}
+
+// Outbox stores the bot's writes to GitHub until they are delivered. Run
+// delivers them in the background, retrying failures with exponential
+// backoff; messages that fail permanently, or too many times, are moved to a
+// dead-letter list from which they can be retried by hand.
+type Outbox struct {
+	githubapp.ClientCreator
+	store  store.Store
+	config Config
+
+	mu     sync.Mutex // serializes delivery passes
+	seq    uint64
+	wake   chan struct{}
+	pruned time.Time
+}
+
+func New(cc githubapp.ClientCreator, s store.Store, c Config) (*Outbox, error) {
+	if err := store.Migrate(s, "outbox", Migrations); err != nil {
+		return nil, err
+	}
+	return &Outbox{ClientCreator: cc, store: s, config: c, wake: make(chan struct{}, 1)}, nil
+}
+
+type originKey struct{}
+
+// WithOrigin returns a context whose messages are given IDs derived from the
+// origin, usually a webhook delivery ID, so that handling the same delivery
+// twice queues each message once.
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// Enqueue stores the message for delivery. Without an ID, the message is
+// given one from its content and the context's origin; without an origin it
+// is unique.
+func (o *Outbox) Enqueue(ctx context.Context, m Message) error {
+	if m.ID == "" {
+		m.ID = id(ctx, m)
+	}
+	if _, err := o.store.Get(idsBucket, m.ID); err == nil {
+		logrus.Infof("Skipping duplicate %s for %s", m.Kind, m.thread())
+		return nil
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	m.CreatedAt = time.Now().UTC()
+	m.NextAttemptAt = m.CreatedAt
+	m.Seq = atomic.AddUint64(&o.seq, 1)
+	if err := store.PutJSON(o.store, pendingBucket, m.key(), m); err != nil {
+		return errors.Wrapf(err, "failed to queue %s", m.Kind)
+	}
+	if err := o.remember(m.ID, "pending"); err != nil {
+		return err
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func id(ctx context.Context, m Message) string {
+	origin, _ := ctx.Value(originKey{}).(string)
+	if origin == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		origin = hex.EncodeToString(b)
+	}
+	content, _ := json.Marshal(m)
+	sum := sha256.Sum256(append([]byte(origin+"\n"), content...))
+	return hex.EncodeToString(sum[:12])
+}
+
+type record struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+func (o *Outbox) remember(id, state string) error {
+	return store.PutJSON(o.store, idsBucket, id, record{State: state, At: time.Now().UTC()})
+}
+
+// Run delivers messages as they are queued and as their retries fall due,
+// until the context is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := o.Flush(ctx); err != nil {
+			logrus.WithError(err).Error("Failed to deliver outbox")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush makes one attempt at delivering every message that is due. A message
+// waiting for a retry holds back the later messages to its thread.
+func (o *Outbox) Flush(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	messages, err := o.Pending()
+	if err != nil {
+		return err
+	}
+	held := map[string]bool{}
+	for _, m := range messages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if held[m.thread()] {
+			continue
+		}
+		if m.NextAttemptAt.After(time.Now()) {
+			held[m.thread()] = true
+			continue
+		}
+		delivered, err := o.deliver(ctx, m)
+		if err != nil {
+			return err
+		}
+		if !delivered {
+			held[m.thread()] = true
+		}
+	}
+
+	if time.Since(o.pruned) > time.Hour {
+		o.pruned = time.Now()
+		return o.prune()
+	}
+	return nil
+}
+
+// deliver attempts the message once, and reports whether it was delivered
+// or given up on, letting the rest of its thread go ahead.
+func (o *Outbox) deliver(ctx context.Context, m Message) (bool, error) {
+	log := logrus.WithFields(logrus.Fields{"id": m.ID, "kind": m.Kind, "thread": m.thread(), "attempt": m.Attempts + 1})
+
+	client, err := o.NewInstallationClient(m.InstallationID)
+	if err == nil {
+		// An earlier attempt may have succeeded without us hearing back.
+		var done bool
+		if m.Attempts > 0 {
+			done, err = delivered(ctx, client, m)
+		}
+		if err == nil && !done {
+			err = Send(ctx, client, m)
+		}
+	}
+	if err == nil {
+		log.Info("Delivered outbox message")
+		if err := o.store.Delete(pendingBucket, m.key()); err != nil {
+			return false, err
+		}
+		return true, o.remember(m.ID, "delivered")
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	m.Attempts++
+	m.LastError = err.Error()
+	retry, wait := o.retry(err, m.Attempts)
+	if !retry || m.Attempts >= o.config.MaxAttempts {
+		log.WithError(err).Error("Giving up on outbox message")
+		return true, o.bury(m)
+	}
+	m.NextAttemptAt = time.Now().UTC().Add(wait)
+	log.WithError(err).Warnf("Failed to deliver outbox message; retrying in %s", wait)
+	return false, store.PutJSON(o.store, pendingBucket, m.key(), m)
+}
+
+// bury moves the message to the dead-letter list.
+func (o *Outbox) bury(m Message) error {
+	if err := store.PutJSON(o.store, deadBucket, m.ID, m); err != nil {
+		return err
+	}
+	if err := o.store.Delete(pendingBucket, m.key()); err != nil {
+		return err
+	}
+	return o.remember(m.ID, "dead")
+}
+
+// Retry moves a message from the dead-letter list back into the queue, or
+// every dead message if id is empty. It returns the number of messages
+// queued.
+func (o *Outbox) Retry(id string) (int, error) {
+	dead, err := o.Dead()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range dead {
+		if id != "" && m.ID != id {
+			continue
+		}
+		// Keep the attempt count so the first retry checks for an earlier
+		// delivery, but start the backoff again.
+		m.CreatedAt = time.Now().UTC()
+		m.NextAttemptAt = m.CreatedAt
+		m.Seq = atomic.AddUint64(&o.seq, 1)
+		if err := store.PutJSON(o.store, pendingBucket, m.key(), m); err != nil {
+			return n, err
+		}
+		if err := o.store.Delete(deadBucket, m.ID); err != nil {
+			return n, err
+		}
+		if err := o.remember(m.ID, "pending"); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if id != "" && n == 0 {
+		return 0, store.ErrNotFound
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return n, nil
+}
+
+// Pending returns the queued messages in the order they will be delivered.
+func (o *Outbox) Pending() ([]Message, error) {
+	return list(o.store, pendingBucket)
+}
+
+// Dead returns the messages that were given up on, in the order they were
+// queued.
+func (o *Outbox) Dead() ([]Message, error) {
+	messages, err := list(o.store, deadBucket)
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].key() < messages[j].key() })
+	return messages, err
+}
+
+func list(s store.Store, bucket string) ([]Message, error) {
+	var messages []Message
+	err := s.ForEach(bucket, func(key string, value []byte) error {
+		var m Message
+		if err := json.Unmarshal(value, &m); err != nil {
+			return errors.Wrapf(err, "failed to decode outbox message %s", key)
+		}
+		messages = append(messages, m)
+		return nil
+	})
+	return messages, err
+}
+
+// prune forgets the IDs of messages delivered longer ago than the retention
+// period.
+func (o *Outbox) prune() error {
+	var expired []string
+	err := o.store.ForEach(idsBucket, func(key string, value []byte) error {
+		var r record
+		if err := json.Unmarshal(value, &r); err != nil {
+			return errors.Wrapf(err, "failed to decode outbox id %s", key)
+		}
+		if r.State == "delivered" && time.Since(r.At) > o.config.Retention {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range expired {
+		if err := o.store.Delete(idsBucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
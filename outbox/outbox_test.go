@@ -0,0 +1,277 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fanatic/git-training/store"
+	"github.com/google/go-github/github"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+// server is a GitHub API that keeps the comments posted to one issue. Each
+// post takes the next status from fail, if any are left; a post that fails
+// with lost set is kept anyway, as if only the response went missing.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	comments []string
+	fail     []int
+	lost     bool
+}
+
+func newServer() *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.URL.Path != "/repos/octo/training/issues/1/comments" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			var comments []github.IssueComment
+			for _, body := range s.comments {
+				comments = append(comments, github.IssueComment{Body: github.String(body)})
+			}
+			json.NewEncoder(w).Encode(comments)
+			return
+		}
+
+		var comment github.IssueComment
+		json.NewDecoder(r.Body).Decode(&comment)
+		if len(s.fail) > 0 {
+			status := s.fail[0]
+			s.fail = s.fail[1:]
+			if s.lost {
+				s.comments = append(s.comments, comment.GetBody())
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"message": "failed"}`))
+			return
+		}
+		s.comments = append(s.comments, comment.GetBody())
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(comment)
+	}))
+	return s
+}
+
+func (s *server) posted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.comments...)
+}
+
+// clients creates clients for the test server.
+type clients struct {
+	githubapp.ClientCreator
+	url string
+}
+
+func (c clients) NewInstallationClient(installationID int64) (*github.Client, error) {
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(c.url + "/")
+	return client, nil
+}
+
+func newOutbox(t *testing.T, s *server, c Config) *Outbox {
+	st, err := store.Open(store.Config{Driver: store.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 3
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = time.Minute
+	}
+	if c.Retention == 0 {
+		c.Retention = time.Hour
+	}
+	o, err := New(clients{url: s.URL}, st, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func count(t *testing.T, list func() ([]Message, error)) int {
+	messages, err := list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(messages)
+}
+
+func comment(body string) Message {
+	return Comment(1, "octo", "training", 1, body)
+}
+
+func TestBackoff(t *testing.T) {
+	o := &Outbox{config: Config{BaseDelay: time.Second, MaxDelay: 10 * time.Second}}
+	for _, tt := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			if got := o.backoff(tt.attempts); got < tt.want || got > tt.want+tt.want/5 {
+				t.Errorf("backoff after %d attempts: got %s, want %s plus up to a fifth", tt.attempts, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRetriesHoldBackTheThread(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	s.fail = []int{http.StatusBadGateway}
+	o := newOutbox(t, s, Config{BaseDelay: time.Hour, MaxDelay: 2 * time.Hour})
+	ctx := context.Background()
+
+	o.Enqueue(ctx, comment("first"))
+	o.Enqueue(ctx, comment("second"))
+	if err := o.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.posted(); len(got) != 0 {
+		t.Fatalf("posted %q while the first comment waits for its retry", got)
+	}
+	pending, _ := o.Pending()
+	if len(pending) != 2 || pending[0].Attempts != 1 || !pending[0].NextAttemptAt.After(time.Now().Add(50*time.Minute)) {
+		t.Fatalf("after a failed attempt: got %+v", pending)
+	}
+
+	// Bring the retry forward.
+	pending[0].NextAttemptAt = time.Now()
+	store.PutJSON(o.store, pendingBucket, pending[0].key(), pending[0])
+	if err := o.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := s.posted()
+	if len(got) != 2 || !strings.HasPrefix(got[0], "first") || !strings.HasPrefix(got[1], "second") {
+		t.Errorf("posted %q, want first then second", got)
+	}
+	if n := count(t, o.Pending); n != 0 {
+		t.Errorf("%d messages still pending", n)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	s.fail = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusUnprocessableEntity}
+	o := newOutbox(t, s, Config{MaxAttempts: 3})
+	ctx := context.Background()
+
+	o.Enqueue(ctx, comment("flaky"))
+	for i := 0; i < 3; i++ {
+		if count(t, o.Dead) != 0 {
+			t.Fatalf("buried after %d attempts, want 3", i)
+		}
+		if err := o.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count(t, o.Dead) != 1 || count(t, o.Pending) != 0 {
+		t.Fatal("didn't give up after 3 attempts")
+	}
+
+	o.Enqueue(ctx, comment("invalid"))
+	if err := o.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count(t, o.Dead) != 2 {
+		t.Fatal("retried a message GitHub rejected")
+	}
+
+	if _, err := o.Retry("missing"); err != store.ErrNotFound {
+		t.Errorf("retrying an unknown message: got %v, want ErrNotFound", err)
+	}
+	n, err := o.Retry("")
+	if err != nil || n != 2 {
+		t.Fatalf("Retry: got %d, %v; want 2", n, err)
+	}
+	if count(t, o.Dead) != 0 || count(t, o.Pending) != 2 {
+		t.Fatal("retried messages weren't queued again")
+	}
+	if err := o.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := s.posted()
+	if len(got) != 2 || !strings.HasPrefix(got[0], "flaky") || !strings.HasPrefix(got[1], "invalid") {
+		t.Errorf("posted %q, want the retried messages in order", got)
+	}
+}
+
+func TestRetryChecksForAnEarlierDelivery(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	s.fail = []int{http.StatusBadGateway}
+	s.lost = true
+	o := newOutbox(t, s, Config{})
+	ctx := context.Background()
+
+	o.Enqueue(ctx, comment("hello"))
+	o.Flush(ctx)
+	o.Flush(ctx)
+	if got := s.posted(); len(got) != 1 {
+		t.Errorf("posted %q, want the comment once", got)
+	}
+	if count(t, o.Pending) != 0 {
+		t.Error("the comment is still pending")
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	o := newOutbox(t, s, Config{})
+	ctx := context.Background()
+
+	delivery := WithOrigin(ctx, "delivery-1")
+	o.Enqueue(delivery, comment("hello"))
+	o.Enqueue(delivery, comment("hello"))
+	if n := count(t, o.Pending); n != 1 {
+		t.Errorf("queued the same message from one delivery %d times", n)
+	}
+	o.Enqueue(WithOrigin(ctx, "delivery-2"), comment("hello"))
+	o.Enqueue(ctx, comment("hello"))
+	o.Enqueue(ctx, comment("hello"))
+	if n := count(t, o.Pending); n != 4 {
+		t.Errorf("got %d messages queued, want 4", n)
+	}
+
+	m := comment("once")
+	m.ID = "once"
+	o.Enqueue(ctx, m)
+	o.Flush(ctx)
+	o.Enqueue(ctx, m)
+	o.Flush(ctx)
+	n := 0
+	for _, body := range s.posted() {
+		if strings.HasPrefix(body, "once") {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("posted a message %d times after queueing its ID again", n)
+	}
+}
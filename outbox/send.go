@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// tag hides the message ID in what it posts, so that a retry can tell whether
+// an earlier attempt got through.
+func tag(m Message) string {
+	return fmt.Sprintf("<!-- git-training-outbox id=%s -->", m.ID)
+}
+
+// Send makes the write described by the message.
+func Send(ctx context.Context, client *github.Client, m Message) error {
+	switch m.Kind {
+	case KindComment:
+		body := m.Body
+		if m.ID != "" {
+			body += "\n" + tag(m)
+		}
+		comment := github.IssueComment{Body: &body}
+		_, _, err := client.Issues.CreateComment(ctx, m.Owner, m.Repo, m.Number, &comment)
+		return errors.Wrap(err, "failed to create issue comment")
+	case KindReview:
+		review := *m.Review
+		if m.ID != "" {
+			body := review.GetBody() + "\n" + tag(m)
+			review.Body = &body
+		}
+		_, _, err := client.PullRequests.CreateReview(ctx, m.Owner, m.Repo, m.Number, &review)
+		return errors.Wrap(err, "failed to create pr review")
+	case KindLabels:
+		_, _, err := client.Issues.AddLabelsToIssue(ctx, m.Owner, m.Repo, m.Number, m.Labels)
+		return errors.Wrap(err, "failed to label issue")
+	default:
+		return errors.Errorf("unknown outbox message kind %q", m.Kind)
+	}
+}
+
+// delivered reports whether the message has already been posted. Labels are
+// safe to add twice and are always sent again.
+func delivered(ctx context.Context, client *github.Client, m Message) (bool, error) {
+	switch m.Kind {
+	case KindComment:
+		opt := &github.IssueListCommentsOptions{Since: m.CreatedAt, ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			comments, resp, err := client.Issues.ListComments(ctx, m.Owner, m.Repo, m.Number, opt)
+			if err != nil {
+				return false, errors.Wrap(err, "failed to list issue comments")
+			}
+			for _, c := range comments {
+				if strings.Contains(c.GetBody(), tag(m)) {
+					return true, nil
+				}
+			}
+			if resp.NextPage == 0 {
+				return false, nil
+			}
+			opt.Page = resp.NextPage
+		}
+	case KindReview:
+		opt := &github.ListOptions{PerPage: 100}
+		for {
+			reviews, resp, err := client.PullRequests.ListReviews(ctx, m.Owner, m.Repo, m.Number, opt)
+			if err != nil {
+				return false, errors.Wrap(err, "failed to list pr reviews")
+			}
+			for _, r := range reviews {
+				if strings.Contains(r.GetBody(), tag(m)) {
+					return true, nil
+				}
+			}
+			if resp.NextPage == 0 {
+				return false, nil
+			}
+			opt.Page = resp.NextPage
+		}
+	default:
+		return false, nil
+	}
+}
+
+// retry reports whether a failed attempt is worth retrying, and how long to
+// wait first. Rate limits are waited out; other client errors are permanent.
+func (o *Outbox) retry(err error, attempts int) (bool, time.Duration) {
+	switch err := errors.Cause(err).(type) {
+	case *github.RateLimitError:
+		return true, time.Until(err.Rate.Reset.Time)
+	case *github.AbuseRateLimitError:
+		if err.RetryAfter != nil {
+			return true, *err.RetryAfter
+		}
+	case *github.ErrorResponse:
+		status := err.Response.StatusCode
+		if status < http.StatusInternalServerError && status != http.StatusTooManyRequests {
+			return false, 0
+		}
+	}
+	return true, o.backoff(attempts)
+}
+
+// backoff doubles the delay with each attempt, up to the maximum, and adds up
+// to a fifth again of jitter.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.config.BaseDelay
+	for i := 1; i < attempts && delay < o.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.config.MaxDelay {
+		delay = o.config.MaxDelay
+	}
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	}
+	return delay
+}
@@ -0,0 +1,270 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// Marker is the machine-readable note the bot hides in every comment and
+// review it posts, recording the step the trainee moved on to. Seq numbers
+// the bot's posts to the trainee, so markers posted within the same second
+// still have an order.
+type Marker struct {
+	Course  string
+	Step    string
+	Trainee string
+	Run     int
+	Issue   int
+	PR      int
+	Branch  string
+	Lang    string
+	Paused  bool
+	Hints   int
+	Seq     int
+}
+
+var markerPattern = regexp.MustCompile(`<!-- git-training ([^>]*)-->`)
+
+// String renders the marker as an HTML comment. A completed course has no
+// step.
+func (m Marker) String() string {
+	fields := []string{"course=" + m.Course}
+	if m.Step != "" {
+		fields = append(fields, "step="+m.Step)
+	}
+	fields = append(fields, "trainee="+m.Trainee)
+	if m.Run != 0 {
+		fields = append(fields, fmt.Sprintf("run=%d", m.Run))
+	}
+	if m.Issue != 0 {
+		fields = append(fields, fmt.Sprintf("issue=%d", m.Issue))
+	}
+	if m.PR != 0 {
+		fields = append(fields, fmt.Sprintf("pr=%d", m.PR))
+	}
+	if m.Branch != "" {
+		fields = append(fields, "branch="+m.Branch)
+	}
+	if m.Lang != "" {
+		fields = append(fields, "lang="+m.Lang)
+	}
+	if m.Paused {
+		fields = append(fields, "paused=true")
+	}
+	if m.Hints != 0 {
+		fields = append(fields, fmt.Sprintf("hints=%d", m.Hints))
+	}
+	if m.Seq != 0 {
+		fields = append(fields, fmt.Sprintf("seq=%d", m.Seq))
+	}
+	return fmt.Sprintf("<!-- git-training %s -->", strings.Join(fields, " "))
+}
+
+// ParseMarker returns the last marker in a comment body.
+func ParseMarker(body string) (Marker, bool) {
+	matches := markerPattern.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return Marker{}, false
+	}
+
+	var m Marker
+	for _, field := range strings.Fields(matches[len(matches)-1][1]) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "course":
+			m.Course = parts[1]
+		case "step":
+			m.Step = parts[1]
+		case "trainee":
+			m.Trainee = parts[1]
+		case "run":
+			m.Run, _ = strconv.Atoi(parts[1])
+		case "issue":
+			m.Issue, _ = strconv.Atoi(parts[1])
+		case "pr":
+			m.PR, _ = strconv.Atoi(parts[1])
+		case "branch":
+			m.Branch = parts[1]
+		case "lang":
+			m.Lang = parts[1]
+		case "paused":
+			m.Paused, _ = strconv.ParseBool(parts[1])
+		case "hints":
+			m.Hints, _ = strconv.Atoi(parts[1])
+		case "seq":
+			m.Seq, _ = strconv.Atoi(parts[1])
+		}
+	}
+	return m, m.Course != "" && m.Trainee != ""
+}
+
+// Markers works out progress from the markers in the bot's comments on the
+// trainee's issues and pull requests, so it needs no storage of its own.
+// Attempts aren't recorded.
+type Markers struct {
+	// Queued, if set, lists the comments and reviews waiting to be posted,
+	// in the order they will be. Their markers are newer than any on GitHub.
+	Queued func() ([]Queued, error)
+}
+
+// Queued is the body of a comment or review the bot has queued but not yet
+// posted, and the repository it is for.
+type Queued struct {
+	Key  Key
+	Body string
+	At   time.Time
+}
+
+// posted is a marker and when the bot posted it.
+type posted struct {
+	marker Marker
+	at     time.Time
+}
+
+func (s Markers) Get(ctx context.Context, client *github.Client, key Key) (*Progress, error) {
+	var markers []posted
+	record := func(body string, user *github.User, at time.Time) {
+		if user.GetType() != "Bot" {
+			return
+		}
+		m, ok := ParseMarker(body)
+		if !ok || m.Trainee != key.Login {
+			return
+		}
+		markers = append(markers, posted{m, at})
+	}
+
+	opts := &github.IssueListByRepoOptions{
+		Creator:     key.Login,
+		State:       "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, key.Owner, key.Repo, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list issues for %s", key)
+		}
+		for _, issue := range issues {
+			if err := collect(ctx, client, key, issue, record); err != nil {
+				return nil, err
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	// Timestamps only have one-second resolution, so markers posted in the
+	// same second are ordered by their sequence number.
+	sort.SliceStable(markers, func(i, j int) bool {
+		if !markers[i].at.Equal(markers[j].at) {
+			return markers[i].at.Before(markers[j].at)
+		}
+		return markers[i].marker.Seq < markers[j].marker.Seq
+	})
+	if s.Queued != nil {
+		queued, err := s.Queued()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list queued posts")
+		}
+		for _, q := range queued {
+			if q.Key.InstallationID != key.InstallationID || !strings.EqualFold(q.Key.Owner, key.Owner) || !strings.EqualFold(q.Key.Repo, key.Repo) {
+				continue
+			}
+			if m, ok := ParseMarker(q.Body); ok && m.Trainee == key.Login {
+				markers = append(markers, posted{m, q.At})
+			}
+		}
+	}
+	if len(markers) == 0 {
+		return nil, nil
+	}
+	first, last := markers[0].at, markers[len(markers)-1].at
+	latest := markers[len(markers)-1].marker
+
+	// Hints and other replies carry the marker too, so the step started with
+	// the first of the run's markers for it.
+	stepStarted := last
+	for i := len(markers) - 1; i >= 0; i-- {
+		m := markers[i].marker
+		if m.Run != latest.Run || m.Step != latest.Step {
+			break
+		}
+		stepStarted = markers[i].at
+	}
+
+	p := &Progress{
+		Key:           key,
+		Course:        latest.Course,
+		Run:           latest.Run,
+		Issue:         latest.Issue,
+		Branch:        latest.Branch,
+		PullRequest:   latest.PR,
+		Lang:          latest.Lang,
+		Step:          latest.Step,
+		Paused:        latest.Paused,
+		Hints:         latest.Hints,
+		Posts:         latest.Seq,
+		StartedAt:     first,
+		StepStartedAt: stepStarted,
+		UpdatedAt:     last,
+	}
+	if p.Completed() {
+		p.CompletedAt = last
+	}
+	return p, nil
+}
+
+// collect passes every comment and review on an issue or pull request to
+// record, a page at a time.
+func collect(ctx context.Context, client *github.Client, key Key, issue *github.Issue, record func(string, *github.User, time.Time)) error {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, key.Owner, key.Repo, issue.GetNumber(), opts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list comments on #%d", issue.GetNumber())
+		}
+		for _, c := range comments {
+			record(c.GetBody(), c.GetUser(), c.GetCreatedAt())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if !issue.IsPullRequest() {
+		return nil
+	}
+	list := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := client.PullRequests.ListReviews(ctx, key.Owner, key.Repo, issue.GetNumber(), list)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list reviews on #%d", issue.GetNumber())
+		}
+		for _, r := range reviews {
+			record(r.GetBody(), r.GetUser(), r.GetSubmittedAt())
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		list.Page = resp.NextPage
+	}
+}
+
+// Save does nothing: the engine records progress by posting the marker.
+func (Markers) Save(ctx context.Context, p *Progress) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+package progress
+
+import "testing"
+
+func TestParseMarker(t *testing.T) {
+	for _, m := range []Marker{
+		{Course: "intro", Step: "step-2", Trainee: "mona", Run: 2, Issue: 7, PR: 8, Branch: "mona-patch-1", Lang: "de", Paused: true, Hints: 2, Seq: 3},
+		{Course: "intro", Trainee: "mona", Seq: 12},
+		{Course: "intro", Step: "step-1", Trainee: "mona"},
+	} {
+		body := "Some instructions.\n\n<!-- git-training course=old trainee=hubot -->\n\n" + m.String()
+		got, ok := ParseMarker(body)
+		if !ok || got != m {
+			t.Errorf("ParseMarker(%q) = %+v, %v; want %+v", m.String(), got, ok, m)
+		}
+	}
+
+	if _, ok := ParseMarker("<!-- git-training step=step-1 -->"); ok {
+		t.Error("parsed a marker without a course or trainee")
+	}
+	if _, ok := ParseMarker("No marker here."); ok {
+		t.Error("parsed a body without a marker")
+	}
+}
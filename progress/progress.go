@@ -0,0 +1,162 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fanatic/git-training/store"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// Key identifies a trainee taking a course in a repository.
+type Key struct {
+	InstallationID int64  `json:"installation_id"`
+	Owner          string `json:"owner"`
+	Repo           string `json:"repo"`
+	Login          string `json:"login"`
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%d/%s/%s/%s", k.InstallationID, k.Owner, k.Repo, k.Login)
+}
+
+// Progress records where a trainee is in a course. Step is the id of the step
+// the trainee is working on and is empty once the course is complete. Run
+// counts the times the trainee has started the course, and Issue links the
+// current run to its training issue. Branch and PullRequest are the trainee's
+// work in this run, once the bot has seen them. Lang is the language the
+// trainee asked for, empty for the default. While Paused, the trainee's
+// events don't move them on. Posts counts the bot's posts to the trainee.
+type Progress struct {
+	Key
+	Course        string    `json:"course"`
+	Run           int       `json:"run"`
+	Issue         int       `json:"issue"`
+	Branch        string    `json:"branch"`
+	PullRequest   int       `json:"pull_request"`
+	Lang          string    `json:"lang,omitempty"`
+	Step          string    `json:"step"`
+	Attempts      int       `json:"attempts"`
+	Hints         int       `json:"hints"`
+	Paused        bool      `json:"paused"`
+	Posts         int       `json:"posts"`
+	StartedAt     time.Time `json:"started_at"`
+	StepStartedAt time.Time `json:"step_started_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	CompletedAt   time.Time `json:"completed_at"`
+}
+
+// New starts a trainee on the given step of a course.
+func New(key Key, course, step string) *Progress {
+	now := time.Now().UTC()
+	return &Progress{
+		Key:           key,
+		Course:        course,
+		Step:          step,
+		StartedAt:     now,
+		StepStartedAt: now,
+		UpdatedAt:     now,
+	}
+}
+
+func (p *Progress) Completed() bool {
+	return p.Step == ""
+}
+
+// Advance moves the trainee on to the given step, or completes the course if
+// step is empty.
+func (p *Progress) Advance(step string) {
+	now := time.Now().UTC()
+	p.Step = step
+	p.Attempts = 0
+	p.Hints = 0
+	p.StepStartedAt = now
+	p.UpdatedAt = now
+	p.CompletedAt = time.Time{}
+	if step == "" {
+		p.CompletedAt = now
+	}
+}
+
+// Hint records that the trainee was given a hint for the current step.
+func (p *Progress) Hint() {
+	p.Hints++
+	p.UpdatedAt = time.Now().UTC()
+}
+
+// Attempt records an event for the current step that failed validation.
+func (p *Progress) Attempt() {
+	p.Attempts++
+	p.UpdatedAt = time.Now().UTC()
+}
+
+// Source loads and saves trainee progress. The client is an installation
+// client for the trainee's repository.
+type Source interface {
+	Get(ctx context.Context, client *github.Client, key Key) (*Progress, error)
+	Save(ctx context.Context, p *Progress) error
+}
+
+const (
+	SourceStore   = "store"
+	SourceMarkers = "markers"
+)
+
+type Config struct {
+	Source string `yaml:"source" default:"store"`
+}
+
+// Open returns the progress source selected by the config. The markers
+// source reads the posts that are still queued from queued, if it is set.
+func Open(c Config, s store.Store, queued func() ([]Queued, error)) (Source, error) {
+	switch c.Source {
+	case SourceStore, "":
+		return NewTracker(s)
+	case SourceMarkers:
+		return Markers{Queued: queued}, nil
+	default:
+		return nil, errors.Errorf("unknown progress source %q", c.Source)
+	}
+}
+
+const bucket = "progress"
+
+// Migrations upgrade stored progress records between releases.
+var Migrations = []store.Migration{
+	{Version: 1, Description: "progress records keyed by installation/owner/repo/login"},
+	// Older records have no issue; it is found from the trainee's open issues.
+	{Version: 2, Description: "link progress to a run and training issue"},
+}
+
+// Tracker keeps the progress of every trainee in a store.
+type Tracker struct {
+	store store.Store
+}
+
+// NewTracker returns a tracker backed by the given store, migrating its
+// progress records first if needed.
+func NewTracker(s store.Store) (*Tracker, error) {
+	if err := store.Migrate(s, bucket, Migrations); err != nil {
+		return nil, err
+	}
+	return &Tracker{store: s}, nil
+}
+
+// Get returns the trainee's progress, or nil if they haven't started a course.
+func (t *Tracker) Get(ctx context.Context, client *github.Client, key Key) (*Progress, error) {
+	var p Progress
+	switch err := store.GetJSON(t.store, bucket, key.String(), &p); err {
+	case nil:
+		return &p, nil
+	case store.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Wrapf(err, "failed to load progress for %s", key)
+	}
+}
+
+func (t *Tracker) Save(ctx context.Context, p *Progress) error {
+	return errors.Wrapf(store.PutJSON(t.store, bucket, p.Key.String(), p), "failed to save progress for %s", p.Key)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/fanatic/git-training/handlers"
+	"github.com/sirupsen/logrus"
+)
+
+// reconcile runs the reconcile subcommand:
+//
+//	git-training reconcile [-installation <id> -repo <owner>/<name>]
+//
+// Without a repository it reconciles every repository the app is installed on.
+// The comments it queues are delivered before it exits; any that fail are
+// left in the outbox for the server to retry.
+func reconcile(engine *handlers.Engine, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	installationID := flags.Int64("installation", 0, "installation id of the repository")
+	repo := flags.String("repo", "", "repository to reconcile, as owner/name")
+	flags.Parse(args)
+
+	var (
+		results []handlers.Reconciliation
+		err     error
+	)
+	if *repo != "" {
+		parts := strings.SplitN(*repo, "/", 2)
+		if len(parts) != 2 || *installationID == 0 {
+			logrus.Fatalf("-installation and -repo <owner>/<name> are required together\n")
+		}
+		results, err = engine.Reconcile(context.Background(), *installationID, parts[0], parts[1])
+	} else {
+		results, err = engine.ReconcileAll(context.Background())
+	}
+
+	if engine.Outbox != nil {
+		if ferr := engine.Outbox.Flush(context.Background()); ferr != nil {
+			logrus.WithError(ferr).Error("Failed to deliver outbox")
+		}
+		if pending, _ := engine.Outbox.Pending(); len(pending) > 0 {
+			logrus.Warnf("%d message(s) left in the outbox for the server to retry", len(pending))
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(results)
+	if err != nil {
+		logrus.Fatalf("Error reconciling: %s\n", err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"regexp"
+
+	"github.com/fanatic/git-training/dryrun"
+	"github.com/fanatic/git-training/githubtest"
+	"github.com/fanatic/git-training/store"
+	"github.com/fanatic/git-training/webhook"
+	"github.com/google/go-github/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// replay runs the replay subcommand:
+//
+//	git-training replay [-dry-run] <file or directory>...
+//
+// It feeds deliveries recorded with webhooks.record_dir through the webhook
+// handler, in the order they were received, against a fake GitHub seeded from
+// the deliveries themselves and fresh in-memory storage, and prints what the
+// bot posted in response to each one. With -dry-run the writes never reach
+// the fake, and the requests the bot would have sent are printed instead.
+func replay(cfg Config, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "print the requests the bot would send instead of sending them")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		logrus.Fatalf("Usage: git-training replay [-dry-run] <file or directory>...\n")
+	}
+	recordings, err := webhook.LoadRecordings(flags.Args()...)
+	if err != nil {
+		logrus.Fatalf("Error loading recordings: %s\n", err)
+	}
+	gh := githubtest.NewServer()
+	defer gh.Close()
+	if err := replayRecordings(cfg, gh, recordings, os.Stdout); err != nil {
+		logrus.Fatalf("Error replaying: %s\n", err)
+	}
+}
+
+// replaySecret signs the replayed deliveries; the recorded signatures were
+// redacted.
+const replaySecret = "replay"
+
+// replayRecordings replays the deliveries against gh, writing a transcript to
+// w.
+func replayRecordings(cfg Config, gh *githubtest.Server, recordings []webhook.Recording, w io.Writer) error {
+	cfg.Github.App.WebhookSecret = replaySecret
+	cfg.Webhooks.RecordDir = ""
+
+	var (
+		cc      = gh.ClientCreator()
+		journal *dryrun.Journal
+	)
+	if cfg.DryRun {
+		journal = dryrun.NewJournal(0)
+		cc = gh.ClientCreator(journal.Middleware)
+	}
+	a, err := newApp(cfg, cc, store.NewMemory(), journal)
+	if err != nil {
+		return err
+	}
+
+	seen := 0
+	for _, rec := range recordings {
+		gh.AddContents(rec.Files)
+		if err := gh.Apply(rec.Event, rec.Payload); err != nil {
+			return errors.Wrapf(err, "failed to apply delivery %s", rec.DeliveryID)
+		}
+
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(rec.Payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", rec.Event)
+		req.Header.Set("X-GitHub-Delivery", rec.DeliveryID)
+		req.Header.Set("X-Hub-Signature", githubtest.Sign(replaySecret, rec.Payload))
+		resp := httptest.NewRecorder()
+		a.handler.ServeHTTP(resp, req)
+		if resp.Code >= 300 {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return errors.Errorf("delivery %s failed with %d: %s", rec.DeliveryID, resp.Code, body)
+		}
+		if err := a.settle(context.Background()); err != nil {
+			return err
+		}
+
+		var payload struct {
+			Action string `json:"action"`
+		}
+		json.Unmarshal(rec.Payload, &payload)
+		fmt.Fprintf(w, "> %s %s %s\n\n", rec.DeliveryID, rec.Event, payload.Action)
+
+		if journal != nil {
+			entries := journal.Entries()
+			writeEntries(w, entries[seen:])
+			seen = len(entries)
+			continue
+		}
+		posts := gh.Posts()
+		writePosts(w, posts[seen:])
+		seen = len(posts)
+	}
+	return nil
+}
+
+// writeEntries writes the writes held back in dry-run mode as a transcript.
+func writeEntries(w io.Writer, entries []dryrun.Entry) {
+	for _, e := range entries {
+		fmt.Fprintf(w, "=== %s %s %s\n%s\n\n", e.Method, e.Path, e.Event, e.Body)
+	}
+	if len(entries) == 0 {
+		io.WriteString(w, "(no response)\n\n")
+	}
+}
+
+// outboxTag is left out of transcripts, as it changes with every wording
+// change.
+var outboxTag = regexp.MustCompile(`\n<!-- git-training-outbox [^>]*-->`)
+
+// writePosts writes the bot's posts as a transcript, or "(no response)" when
+// there are none.
+func writePosts(w io.Writer, posts []githubtest.Post) {
+	for _, p := range posts {
+		switch p.Kind {
+		case "review":
+			fmt.Fprintf(w, "=== review %s on #%d\n", p.Event, p.Number)
+		default:
+			fmt.Fprintf(w, "=== %s on #%d\n", p.Kind, p.Number)
+		}
+		fmt.Fprintf(w, "%s\n", outboxTag.ReplaceAllString(p.Body, ""))
+		for _, c := range p.Comments {
+			fmt.Fprintf(w, "--- on %s, position %d\n%s\n", c.GetPath(), c.GetPosition(), c.GetBody())
+		}
+		io.WriteString(w, "\n")
+	}
+	if len(posts) == 0 {
+		io.WriteString(w, "(no response)\n\n")
+	}
+}
+
+// maxRecordedFiles bounds the API calls made to record one push.
+const maxRecordedFiles = 20
+
+// recordFiles returns an enricher that records the contents of the files a
+// push added or modified, which the push payload only names, so that a replay
+// validates the same files the trainee pushed.
+func recordFiles(cc githubapp.ClientCreator) webhook.Enricher {
+	return func(ctx context.Context, rec *webhook.Recording) error {
+		if rec.Event != "push" {
+			return nil
+		}
+		var event github.PushEvent
+		if err := json.Unmarshal(rec.Payload, &event); err != nil {
+			return errors.Wrap(err, "failed to parse push event payload")
+		}
+		if event.GetDeleted() {
+			return nil
+		}
+		client, err := cc.NewInstallationClient(githubapp.GetInstallationIDFromEvent(&event))
+		if err != nil {
+			return err
+		}
+		owner, repo := event.GetRepo().GetOwner().GetName(), event.GetRepo().GetName()
+
+		fetched := 0
+		for _, c := range event.Commits {
+			for _, path := range append(append([]string(nil), c.Added...), c.Modified...) {
+				if fetched == maxRecordedFiles {
+					return nil
+				}
+				fetched++
+				file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: c.GetID()})
+				if err != nil {
+					return errors.Wrapf(err, "failed to get %s at %s", path, c.GetID())
+				}
+				if file == nil {
+					continue
+				}
+				content, err := file.GetContent()
+				if err != nil {
+					return errors.Wrapf(err, "failed to decode %s at %s", path, c.GetID())
+				}
+				if rec.Files == nil {
+					rec.Files = map[string]map[string]string{}
+				}
+				if rec.Files[c.GetID()] == nil {
+					rec.Files[c.GetID()] = map[string]string{}
+				}
+				rec.Files[c.GetID()][path] = content
+			}
+		}
+		return nil
+	}
+}
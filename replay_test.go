@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ctrlaltdel121/configor"
+	"github.com/fanatic/git-training/githubtest"
+	"github.com/fanatic/git-training/webhook"
+)
+
+func TestReplayRecordedCourse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gh, sim := newTestApp(t, func(cfg *Config) { cfg.Webhooks.RecordDir = dir })
+	defer gh.Close()
+	octocat := sim.Trainee(testRepo, "octocat")
+	octocat.OpenIssue("Training", "")
+	octocat.Assign(1, "octocat")
+	octocat.CreateBranch("feat/octocat-1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Hello, world!\n", "Create octocat.md")
+	octocat.OpenPullRequest("feat/octocat-1", "Add octocat's file", "Resolves #1")
+	octocat.Commit("feat/octocat-1", "users/octocat.md", "Talk is cheap. Show me the code.\n", "Update octocat.md")
+	octocat.MergePullRequest(2)
+
+	// A delivery that isn't signed with the secret is rejected and not
+	// recorded.
+	forged := httptest.NewRequest("POST", "/", strings.NewReader(`{"action":"opened"}`))
+	forged.Header.Set("Content-Type", "application/json")
+	forged.Header.Set("X-GitHub-Event", "issues")
+	forged.Header.Set("X-GitHub-Delivery", "forged")
+	forged.Header.Set("X-Hub-Signature", "sha1=0000")
+	sim.Handler.ServeHTTP(httptest.NewRecorder(), forged)
+	sim.Settle()
+
+	recordings, err := webhook.LoadRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recordings {
+		if rec.DeliveryID == "forged" {
+			t.Error("a delivery with a bad signature was recorded")
+		}
+		for name := range rec.Headers {
+			if strings.HasPrefix(name, "X-Hub-Signature") {
+				t.Errorf("delivery %s was recorded with its %s header", rec.DeliveryID, name)
+			}
+		}
+	}
+
+	var cfg Config
+	if err := configor.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	replayed := githubtest.NewServer()
+	defer replayed.Close()
+	var out bytes.Buffer
+	if err := replayRecordings(cfg, replayed, recordings, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := gh.Posts(), replayed.Posts()
+	if len(got) != len(want) {
+		t.Fatalf("replay made %d posts, the original run %d:\n%s", len(got), len(want), out.String())
+	}
+	for i := range want {
+		if got[i].Kind != want[i].Kind || got[i].Number != want[i].Number || got[i].Body != want[i].Body {
+			t.Errorf("post %d: replay made %s on #%d\n%s\nwant %s on #%d\n%s", i, got[i].Kind, got[i].Number, got[i].Body, want[i].Kind, want[i].Number, want[i].Body)
+		}
+	}
+}
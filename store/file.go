@@ -0,0 +1,96 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// file is a memory store that rewrites a JSON file after every change.
+type file struct {
+	*memory
+	path string
+	mu   sync.Mutex
+}
+
+// OpenFile returns a store persisted to a single, human readable JSON file.
+// It suits small installations; every write rewrites the whole file.
+func OpenFile(path string) (Store, error) {
+	f := &file{memory: NewMemory().(*memory), path: path}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return f, nil
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	var buckets map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	for bucket, values := range buckets {
+		for key, value := range values {
+			f.memory.Put(bucket, key, value)
+		}
+	}
+	return f, nil
+}
+
+func (f *file) Put(bucket, key string, value []byte) error {
+	if !json.Valid(value) {
+		return errors.Errorf("value for %s/%s is not JSON", bucket, key)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memory.Put(bucket, key, value)
+	return f.flush()
+}
+
+func (f *file) Delete(bucket, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memory.Delete(bucket, key)
+	return f.flush()
+}
+
+// flush writes the store to a temporary file and renames it into place, so a
+// crash mid-write never leaves a truncated file behind.
+func (f *file) flush() error {
+	f.memory.mu.RLock()
+	buckets := make(map[string]map[string]json.RawMessage, len(f.memory.buckets))
+	for bucket, values := range f.memory.buckets {
+		buckets[bucket] = make(map[string]json.RawMessage, len(values))
+		for key, value := range values {
+			buckets[bucket][key] = value
+		}
+	}
+	data, err := json.MarshalIndent(buckets, "", "  ")
+	f.memory.mu.RUnlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode store")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), f.path), "failed to replace %s", f.path)
+}
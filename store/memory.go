@@ -0,0 +1,77 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+type memory struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemory returns a store that keeps everything in memory. Its contents are
+// lost when the process exits, which makes it best suited to tests.
+func NewMemory() Store {
+	return &memory{buckets: map[string]map[string][]byte{}}
+}
+
+func (m *memory) Get(bucket, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBytes(value), nil
+}
+
+func (m *memory) Put(bucket, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.buckets[bucket] == nil {
+		m.buckets[bucket] = map[string][]byte{}
+	}
+	m.buckets[bucket][key] = copyBytes(value)
+	return nil
+}
+
+func (m *memory) Delete(bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+func (m *memory) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	m.mu.RLock()
+	b := m.buckets[bucket]
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	values := make(map[string][]byte, len(b))
+	for _, key := range keys {
+		values[key] = copyBytes(b[key])
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memory) Close() error {
+	return nil
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
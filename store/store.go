@@ -0,0 +1,129 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by Get when the key doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Store is a bucketed key/value store for the bot's state. Values are JSON
+// documents.
+type Store interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// ForEach calls fn for every key in the bucket, in key order. fn must not
+	// modify the store.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+const (
+	DriverMemory = "memory"
+	DriverFile   = "file"
+	DriverBolt   = "bolt"
+)
+
+type Config struct {
+	Driver string `yaml:"driver" default:"memory"`
+	Path   string `yaml:"path"`
+}
+
+// Open creates the store selected by the config.
+func Open(c Config) (Store, error) {
+	switch c.Driver {
+	case DriverMemory, "":
+		return NewMemory(), nil
+	case DriverFile, DriverBolt:
+		if c.Path == "" {
+			return nil, errors.Errorf("storage driver %s requires a path", c.Driver)
+		}
+		if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+			return nil, errors.Wrap(err, "failed to create storage directory")
+		}
+		if c.Driver == DriverFile {
+			return OpenFile(c.Path)
+		}
+		return OpenBolt(c.Path)
+	default:
+		return nil, errors.Errorf("unknown storage driver %q", c.Driver)
+	}
+}
+
+// GetJSON decodes the value stored under key into v.
+func GetJSON(s Store, bucket, key string, v interface{}) error {
+	value, err := s.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(json.Unmarshal(value, v), "failed to decode %s/%s", bucket, key)
+}
+
+// PutJSON stores v under key as JSON.
+func PutJSON(s Store, bucket, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %s/%s", bucket, key)
+	}
+	return s.Put(bucket, key, value)
+}
+
+const metaBucket = "meta"
+
+// Migration upgrades the records of one schema to the given version.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(Store) error
+}
+
+// Migrate brings the named schema up to date by running, in order, every
+// migration newer than the version recorded in the store. It refuses to touch
+// a store written by a newer release.
+func Migrate(s Store, schema string, migrations []Migration) error {
+	key := "schema_version/" + schema
+
+	version := 0
+	value, err := s.Get(metaBucket, key)
+	switch {
+	case err == ErrNotFound:
+	case err != nil:
+		return err
+	default:
+		if version, err = strconv.Atoi(string(value)); err != nil {
+			return errors.Wrapf(err, "invalid %s schema version %q", schema, value)
+		}
+	}
+
+	latest := 0
+	for _, m := range migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	if version > latest {
+		return errors.Errorf("%s schema version %d is newer than this release supports (%d)", schema, version, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if m.Up != nil {
+			if err := m.Up(s); err != nil {
+				return errors.Wrapf(err, "failed to migrate %s schema to version %d (%s)", schema, m.Version, m.Description)
+			}
+		}
+		if err := s.Put(metaBucket, key, []byte(strconv.Itoa(m.Version))); err != nil {
+			return err
+		}
+		version = m.Version
+	}
+	return nil
+}
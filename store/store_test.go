@@ -0,0 +1,194 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// driver opens an empty store of one kind, and reopen opens the same store
+// again after it's closed, for the kinds that persist.
+type driver struct {
+	name   string
+	open   func(t *testing.T) Store
+	reopen func(t *testing.T) Store
+}
+
+// drivers returns a driver of each kind, and a function that removes their
+// files.
+func drivers(t *testing.T) ([]driver, func()) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opener := func(driver string) func(t *testing.T) Store {
+		return func(t *testing.T) Store {
+			s, err := Open(Config{Driver: driver, Path: filepath.Join(dir, driver, "state")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}
+	}
+	return []driver{
+		{name: DriverMemory, open: opener(DriverMemory)},
+		{name: DriverFile, open: opener(DriverFile), reopen: opener(DriverFile)},
+		{name: DriverBolt, open: opener(DriverBolt), reopen: opener(DriverBolt)},
+	}, func() { os.RemoveAll(dir) }
+}
+
+func TestStores(t *testing.T) {
+	ds, cleanup := drivers(t)
+	defer cleanup()
+
+	for _, d := range ds {
+		t.Run(d.name, func(t *testing.T) {
+			s := d.open(t)
+
+			if _, err := s.Get("things", "a"); err != ErrNotFound {
+				t.Errorf("Get from a missing bucket: got %v, want ErrNotFound", err)
+			}
+			for _, key := range []string{"b", "a", "c"} {
+				if err := s.Put("things", key, []byte(`"`+key+`"`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Put("things", "b", []byte(`"B"`)); err != nil {
+				t.Fatal(err)
+			}
+			if value, err := s.Get("things", "b"); err != nil || string(value) != `"B"` {
+				t.Errorf("Get b: got %s, %v; want \"B\"", value, err)
+			}
+			if _, err := s.Get("things", "d"); err != ErrNotFound {
+				t.Errorf("Get a missing key: got %v, want ErrNotFound", err)
+			}
+
+			if err := s.Delete("things", "c"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete("things", "c"); err != nil {
+				t.Errorf("Delete a missing key: %v", err)
+			}
+			if err := s.Delete("nothing", "c"); err != nil {
+				t.Errorf("Delete from a missing bucket: %v", err)
+			}
+			want := map[string]string{"a": `"a"`, "b": `"B"`}
+			if got := list(t, s, "things"); !reflect.DeepEqual(got, want) {
+				t.Errorf("ForEach: got %v, want %v", got, want)
+			}
+			if got := list(t, s, "nothing"); len(got) != 0 {
+				t.Errorf("ForEach over a missing bucket: got %v", got)
+			}
+
+			var keys []string
+			s.ForEach("things", func(key string, value []byte) error {
+				keys = append(keys, key)
+				return nil
+			})
+			if strings.Join(keys, ",") != "a,b" {
+				t.Errorf("ForEach order: got %v, want a, b", keys)
+			}
+
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if d.reopen == nil {
+				return
+			}
+			s = d.reopen(t)
+			defer s.Close()
+			if got := list(t, s, "things"); !reflect.DeepEqual(got, want) {
+				t.Errorf("after reopening: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileStoreRejectsValuesThatArentJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := OpenFile(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("things", "a", []byte("not json")); err == nil {
+		t.Error("stored a value that isn't JSON")
+	}
+	if err := PutJSON(s, "things", "a", "not json"); err != nil {
+		t.Errorf("PutJSON: %v", err)
+	}
+	var got string
+	if err := GetJSON(s, "things", "a", &got); err != nil || got != "not json" {
+		t.Errorf("GetJSON: got %q, %v", got, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ds, cleanup := drivers(t)
+	defer cleanup()
+
+	for _, d := range ds {
+		t.Run(d.name, func(t *testing.T) {
+			s := d.open(t)
+			defer s.Close()
+
+			var ran []int
+			migration := func(version int) Migration {
+				return Migration{Version: version, Up: func(Store) error {
+					ran = append(ran, version)
+					return nil
+				}}
+			}
+			migrations := []Migration{migration(1), {Version: 2, Description: "nothing to do"}}
+			if err := Migrate(s, "things", migrations); err != nil {
+				t.Fatal(err)
+			}
+			if err := Migrate(s, "things", append(migrations, migration(3))); err != nil {
+				t.Fatal(err)
+			}
+			if err := Migrate(s, "things", append(migrations, migration(3))); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ran, []int{1, 3}) {
+				t.Errorf("ran migrations %v, want 1 then 3", ran)
+			}
+			if err := Migrate(s, "other", nil); err != nil {
+				t.Errorf("migrating another schema: %v", err)
+			}
+
+			err := Migrate(s, "things", migrations)
+			if err == nil || !strings.Contains(err.Error(), "newer than this release supports") {
+				t.Errorf("migrating back to version 2: got %v, want an error", err)
+			}
+
+			failing := Migration{Version: 4, Description: "fail", Up: func(Store) error { return ErrNotFound }}
+			if err := Migrate(s, "things", append(migrations, migration(3), failing)); err == nil {
+				t.Error("a failed migration didn't return an error")
+			}
+			if err := Migrate(s, "things", append(migrations, migration(3), migration(4))); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ran, []int{1, 3, 4}) {
+				t.Errorf("after retrying migration 4: ran %v", ran)
+			}
+		})
+	}
+}
+
+func list(t *testing.T, s Store, bucket string) map[string]string {
+	values := map[string]string{}
+	if err := s.ForEach(bucket, func(key string, value []byte) error {
+		values[key] = string(value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
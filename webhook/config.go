@@ -0,0 +1,18 @@
+package webhook
+
+import "time"
+
+type Config struct {
+	// DedupTTL is how long handled deliveries are remembered.
+	DedupTTL time.Duration `yaml:"dedup_ttl" default:"72h"`
+	// Workers is the number of deliveries handled at once, and QueueSize the
+	// number each worker can have waiting.
+	Workers   int `yaml:"workers" default:"4"`
+	QueueSize int `yaml:"queue_size" default:"100"`
+	// ShutdownTimeout bounds how long the server waits for queued deliveries
+	// when it is stopped.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" default:"30s"`
+	// RecordDir, if set, is where every delivery is written as it arrives,
+	// for the replay command.
+	RecordDir string `yaml:"record_dir"`
+}
@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/store"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+const (
+	deliveriesBucket = "deliveries"
+	payloadsBucket   = "delivery_payloads"
+)
+
+// Migrations are the versions of the delivery schema.
+var Migrations = []store.Migration{
+	{Version: 1, Description: "handled deliveries and their payload hashes"},
+}
+
+type delivery struct {
+	EventType string    `json:"event_type"`
+	Hash      string    `json:"hash"`
+	HandledAt time.Time `json:"handled_at"`
+}
+
+// Deduplicator remembers the deliveries that were handled, so that each is
+// handled once. A delivery is recognised by its X-GitHub-Delivery ID, and a
+// payload that was already handled under another ID, such as one resent by
+// hand, is recognised by its hash and reported. Deliveries are forgotten
+// after the TTL.
+type Deduplicator struct {
+	store store.Store
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	pruned   time.Time
+}
+
+func NewDeduplicator(s store.Store, ttl time.Duration) (*Deduplicator, error) {
+	if err := store.Migrate(s, "deliveries", Migrations); err != nil {
+		return nil, err
+	}
+	return &Deduplicator{store: s, ttl: ttl, inFlight: map[string]bool{}}, nil
+}
+
+// Wrap returns a handler that passes each delivery on to h once.
+func (d *Deduplicator) Wrap(h githubapp.EventHandler) githubapp.EventHandler {
+	return &dedupHandler{EventHandler: h, d: d}
+}
+
+type dedupHandler struct {
+	githubapp.EventHandler
+	d *Deduplicator
+}
+
+func (h *dedupHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	hash := hashOf(eventType, payload)
+	if ok, err := h.d.claim(eventType, deliveryID, hash); err != nil || !ok {
+		return err
+	}
+	err := h.EventHandler.Handle(ctx, eventType, deliveryID, payload)
+	return h.d.release(eventType, deliveryID, hash, err)
+}
+
+// hashOf identifies a payload of the given event type.
+func hashOf(eventType string, payload []byte) string {
+	sum := sha256.Sum256(append([]byte(eventType+"\n"), payload...))
+	return hex.EncodeToString(sum[:])
+}
+
+// claim reports whether the delivery should be handled, marking it as in
+// flight if so.
+func (d *Deduplicator) claim(eventType, deliveryID, hash string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.inFlight[deliveryID] || d.inFlight[hash] {
+		logrus.Infof("Dropping %s delivery %s because it is already being handled", eventType, deliveryID)
+		return false, nil
+	}
+
+	var seen delivery
+	switch err := store.GetJSON(d.store, deliveriesBucket, deliveryID, &seen); {
+	case err == nil && time.Since(seen.HandledAt) < d.ttl:
+		logrus.Infof("Dropping %s delivery %s because it was handled at %s", eventType, deliveryID, seen.HandledAt.Format(time.RFC3339))
+		return false, nil
+	case err != nil && err != store.ErrNotFound:
+		return false, err
+	}
+
+	var original string
+	switch err := store.GetJSON(d.store, payloadsBucket, hash, &original); {
+	case err == nil:
+		if err := store.GetJSON(d.store, deliveriesBucket, original, &seen); err == nil && time.Since(seen.HandledAt) < d.ttl {
+			logrus.Warnf("Dropping %s delivery %s because it is a redelivery of %s, handled at %s", eventType, deliveryID, original, seen.HandledAt.Format(time.RFC3339))
+			return false, nil
+		}
+	case err != store.ErrNotFound:
+		return false, err
+	}
+
+	d.inFlight[deliveryID] = true
+	d.inFlight[hash] = true
+	return true, nil
+}
+
+// release clears the delivery from the in-flight set and, if it was handled,
+// records it. Failed deliveries can be tried again.
+func (d *Deduplicator) release(eventType, deliveryID, hash string, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.inFlight, deliveryID)
+	delete(d.inFlight, hash)
+	if err != nil {
+		return err
+	}
+
+	if err := store.PutJSON(d.store, deliveriesBucket, deliveryID, delivery{EventType: eventType, Hash: hash, HandledAt: time.Now().UTC()}); err != nil {
+		return errors.Wrapf(err, "failed to record delivery %s", deliveryID)
+	}
+	if err := store.PutJSON(d.store, payloadsBucket, hash, deliveryID); err != nil {
+		return errors.Wrapf(err, "failed to record delivery %s", deliveryID)
+	}
+
+	if time.Since(d.pruned) > time.Hour {
+		d.pruned = time.Now()
+		return d.prune()
+	}
+	return nil
+}
+
+// prune forgets the deliveries handled longer ago than the TTL.
+func (d *Deduplicator) prune() error {
+	expired := map[string]string{}
+	err := d.store.ForEach(deliveriesBucket, func(key string, value []byte) error {
+		var seen delivery
+		if err := json.Unmarshal(value, &seen); err != nil {
+			return errors.Wrapf(err, "failed to decode delivery %s", key)
+		}
+		if time.Since(seen.HandledAt) >= d.ttl {
+			expired[key] = seen.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, hash := range expired {
+		if err := d.store.Delete(deliveriesBucket, id); err != nil {
+			return err
+		}
+		var latest string
+		if err := store.GetJSON(d.store, payloadsBucket, hash, &latest); err == nil && latest == id {
+			if err := d.store.Delete(payloadsBucket, hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fanatic/git-training/store"
+)
+
+// counter counts the deliveries it handles, failing while fail is set and
+// waiting for release, if set, before it returns.
+type counter struct {
+	mu      sync.Mutex
+	handled map[string]int
+	fail    bool
+	release chan struct{}
+}
+
+func (c *counter) Handles() []string { return []string{"issues"} }
+
+func (c *counter) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	if c.release != nil {
+		<-c.release
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail {
+		return errors.New("failed")
+	}
+	if c.handled == nil {
+		c.handled = map[string]int{}
+	}
+	c.handled[deliveryID]++
+	return nil
+}
+
+func (c *counter) count(deliveryID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handled[deliveryID]
+}
+
+// stores opens a store of each kind in dir. Stores of the kinds that persist
+// are opened from the same files each time.
+func stores(t *testing.T, dir string) map[string]store.Store {
+	stores := map[string]store.Store{}
+	for _, driver := range []string{store.DriverMemory, store.DriverFile, store.DriverBolt} {
+		s, err := store.Open(store.Config{Driver: driver, Path: filepath.Join(dir, driver)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores[driver] = s
+	}
+	return stores
+}
+
+func TestDeduplicator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	payload := []byte(`{"action": "opened"}`)
+
+	for driver, s := range stores(t, dir) {
+		t.Run(driver, func(t *testing.T) {
+			d, err := NewDeduplicator(s, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &counter{}
+			handler := d.Wrap(h)
+
+			h.fail = true
+			if err := handler.Handle(ctx, "issues", "delivery-1", payload); err == nil {
+				t.Fatal("a failed delivery didn't return its error")
+			}
+			h.fail = false
+			handler.Handle(ctx, "issues", "delivery-1", payload)
+			handler.Handle(ctx, "issues", "delivery-1", payload)
+			if n := h.count("delivery-1"); n != 1 {
+				t.Errorf("handled delivery-1 %d times, want once after its failure", n)
+			}
+
+			handler.Handle(ctx, "issues", "delivery-2", payload)
+			if n := h.count("delivery-2"); n != 0 {
+				t.Error("handled a redelivery of the same payload")
+			}
+			handler.Handle(ctx, "issue_comment", "delivery-3", payload)
+			handler.Handle(ctx, "issues", "delivery-4", []byte(`{"action": "closed"}`))
+			if h.count("delivery-3") != 1 || h.count("delivery-4") != 1 {
+				t.Error("dropped a delivery with a different event type or payload")
+			}
+
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if driver == store.DriverMemory {
+				return
+			}
+			s, err := store.Open(store.Config{Driver: driver, Path: filepath.Join(dir, driver)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if d, err = NewDeduplicator(s, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			handler = d.Wrap(h)
+			handler.Handle(ctx, "issues", "delivery-1", payload)
+			handler.Handle(ctx, "issues", "delivery-5", payload)
+			if h.count("delivery-1") != 1 || h.count("delivery-5") != 0 {
+				t.Error("handled a delivery again after reopening the store")
+			}
+		})
+	}
+}
+
+func TestDeduplicatorForgetsAfterTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	payload := []byte(`{"action": "opened"}`)
+
+	for driver, s := range stores(t, dir) {
+		t.Run(driver, func(t *testing.T) {
+			defer s.Close()
+			d, err := NewDeduplicator(s, 20*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &counter{}
+			handler := d.Wrap(h)
+
+			handler.Handle(ctx, "issues", "delivery-1", payload)
+			time.Sleep(30 * time.Millisecond)
+			handler.Handle(ctx, "issues", "delivery-1", payload)
+			handler.Handle(ctx, "issues", "delivery-2", payload)
+			if h.count("delivery-1") != 2 || h.count("delivery-2") != 0 {
+				t.Errorf("handled 1 %d times and 2 %d times, want 2 and 0", h.count("delivery-1"), h.count("delivery-2"))
+			}
+
+			// The payload is recorded against the delivery that last
+			// handled it.
+			var id string
+			if err := store.GetJSON(s, payloadsBucket, hashOf("issues", payload), &id); err != nil || id != "delivery-1" {
+				t.Errorf("payload recorded for delivery %q, %v; want delivery-1", id, err)
+			}
+		})
+	}
+}
+
+func TestDeduplicatorDropsDeliveriesInFlight(t *testing.T) {
+	s, err := store.Open(store.Config{Driver: store.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDeduplicator(s, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &counter{release: make(chan struct{})}
+	handler := d.Wrap(h)
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		handler.Handle(ctx, "issues", "delivery-1", []byte(`{}`))
+		close(done)
+	}()
+	for {
+		d.mu.Lock()
+		inFlight := d.inFlight["delivery-1"]
+		d.mu.Unlock()
+		if inFlight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	handler.Handle(ctx, "issues", "delivery-1", []byte(`{}`))
+	handler.Handle(ctx, "issues", "delivery-2", []byte(`{}`))
+	close(h.release)
+	<-done
+	if h.count("delivery-1") != 1 || h.count("delivery-2") != 0 {
+		t.Errorf("handled 1 %d times and 2 %d times, want 1 and 0", h.count("delivery-1"), h.count("delivery-2"))
+	}
+}
@@ -0,0 +1,191 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/fanatic/git-training/handlers"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+// ErrClosed is returned for deliveries that arrive after the queue was
+// closed.
+var ErrClosed = errors.New("webhook queue is closed")
+
+type job struct {
+	handler    githubapp.EventHandler
+	eventType  string
+	deliveryID string
+	payload    []byte
+}
+
+// Queue hands deliveries to a pool of workers so that webhooks can be
+// acknowledged straight away. Deliveries for the same trainee always go to the
+// same worker, which handles them one at a time in the order they arrived.
+type Queue struct {
+	shards []chan job
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	idle    *sync.Cond
+	pending int
+}
+
+// NewQueue starts the given number of workers, each with room for size
+// waiting deliveries.
+func NewQueue(workers, size int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &Queue{idle: sync.NewCond(&sync.Mutex{})}
+	for i := 0; i < workers; i++ {
+		shard := make(chan job, size)
+		q.shards = append(q.shards, shard)
+		q.wg.Add(1)
+		go q.work(shard)
+	}
+	return q
+}
+
+// Wrap returns a handler that queues deliveries for h.
+func (q *Queue) Wrap(h githubapp.EventHandler) githubapp.EventHandler {
+	return &queuedHandler{EventHandler: h, q: q}
+}
+
+type queuedHandler struct {
+	githubapp.EventHandler
+	q *Queue
+}
+
+func (h *queuedHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	return h.q.push(ctx, job{handler: h.EventHandler, eventType: eventType, deliveryID: deliveryID, payload: payload})
+}
+
+// push queues the job on its trainee's worker, waiting for room if the
+// worker is behind.
+func (q *Queue) push(ctx context.Context, j job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(TraineeKey(j.eventType, j.payload)))
+	q.add(1)
+	select {
+	case q.shards[h.Sum32()%uint32(len(q.shards))] <- j:
+		return nil
+	case <-ctx.Done():
+		q.add(-1)
+		return errors.Wrapf(ctx.Err(), "failed to queue %s delivery %s", j.eventType, j.deliveryID)
+	}
+}
+
+func (q *Queue) add(n int) {
+	q.idle.L.Lock()
+	q.pending += n
+	if q.pending == 0 {
+		q.idle.Broadcast()
+	}
+	q.idle.L.Unlock()
+}
+
+// Wait blocks until every queued delivery has been handled.
+func (q *Queue) Wait() {
+	q.idle.L.Lock()
+	for q.pending > 0 {
+		q.idle.Wait()
+	}
+	q.idle.L.Unlock()
+}
+
+func (q *Queue) work(shard chan job) {
+	defer q.wg.Done()
+	for j := range shard {
+		q.handle(j)
+		q.add(-1)
+	}
+}
+
+func (q *Queue) handle(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Panic handling %s delivery %s: %v", j.eventType, j.deliveryID, r)
+		}
+	}()
+	if err := j.handler.Handle(context.Background(), j.eventType, j.deliveryID, j.payload); err != nil {
+		logrus.WithError(err).Errorf("Failed to handle %s delivery %s", j.eventType, j.deliveryID)
+	}
+}
+
+// Drain stops accepting deliveries and waits for the queued ones to be
+// handled, or for the context to be done.
+func (q *Queue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, shard := range q.shards {
+			close(shard)
+		}
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "gave up waiting for queued deliveries")
+	}
+}
+
+// TraineeKey returns the installation, repository and login a delivery
+// belongs to, picking the trainee the way the handlers do: the issue's author
+// for issues events, the trainee an instructor's command is for, and the
+// sender for everything else.
+func TraineeKey(eventType string, payload []byte) string {
+	var event struct {
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Issue struct {
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		} `json:"issue"`
+		Comment struct {
+			Body string `json:"body"`
+		} `json:"comment"`
+		Sender struct {
+			Login string `json:"login"`
+		} `json:"sender"`
+	}
+	json.Unmarshal(payload, &event)
+
+	login := event.Sender.Login
+	switch eventType {
+	case "issues":
+		login = event.Issue.User.Login
+	case "issue_comment":
+		if target, ok := handlers.CommandTarget(event.Comment.Body, event.Issue.User.Login); ok {
+			login = target
+		}
+	}
+	return fmt.Sprintf("%d/%s/%s", event.Installation.ID, event.Repository.FullName, login)
+}
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ordered records the order it handles each trainee's deliveries in.
+type ordered struct {
+	mu      sync.Mutex
+	handled map[string][]string
+	release chan struct{}
+}
+
+func (r *ordered) Handles() []string { return []string{"push"} }
+
+func (r *ordered) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	if r.release != nil {
+		<-r.release
+	}
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handled == nil {
+		r.handled = map[string][]string{}
+	}
+	key := TraineeKey(eventType, payload)
+	r.handled[key] = append(r.handled[key], deliveryID)
+	return nil
+}
+
+func push(login string) []byte {
+	return []byte(fmt.Sprintf(`{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "sender": {"login": %q}}`, login))
+}
+
+func TestQueueKeepsEachTraineesOrder(t *testing.T) {
+	q := NewQueue(4, 10)
+	r := &ordered{}
+	h := q.Wrap(r)
+	ctx := context.Background()
+
+	trainees := []string{"octocat", "mona", "hubot", "monalisa", "defunkt", "pjhyett"}
+	want := map[string][]string{}
+	for i := 0; i < 20; i++ {
+		for _, login := range trainees {
+			id := fmt.Sprintf("%s-%d", login, i)
+			if err := h.Handle(ctx, "push", id, push(login)); err != nil {
+				t.Fatal(err)
+			}
+			key := TraineeKey("push", push(login))
+			want[key] = append(want[key], id)
+		}
+	}
+	if err := q.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.handled, want) {
+		t.Errorf("handled deliveries in the wrong order:\ngot  %v\nwant %v", r.handled, want)
+	}
+}
+
+func TestQueueDrain(t *testing.T) {
+	q := NewQueue(2, 10)
+	r := &ordered{release: make(chan struct{})}
+	h := q.Wrap(r)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		h.Handle(ctx, "push", fmt.Sprint(i), push("octocat"))
+	}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(timeout); err == nil {
+		t.Error("Drain returned while deliveries were still being handled")
+	}
+	if err := h.Handle(ctx, "push", "late", push("octocat")); err != ErrClosed {
+		t.Errorf("queueing after Drain: got %v, want ErrClosed", err)
+	}
+
+	close(r.release)
+	if err := q.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.handled[TraineeKey("push", push("octocat"))]; !reflect.DeepEqual(got, []string{"0", "1", "2"}) {
+		t.Errorf("handled %v before draining, want 0, 1 and 2", got)
+	}
+}
+
+func TestTraineeKey(t *testing.T) {
+	for _, tt := range []struct {
+		eventType string
+		payload   string
+		want      string
+	}{
+		{"push", `{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "sender": {"login": "octocat"}}`, "1/octo/training/octocat"},
+		{"issues", `{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "issue": {"user": {"login": "octocat"}}, "sender": {"login": "mona"}}`, "1/octo/training/octocat"},
+		{"issue_comment", `{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "issue": {"user": {"login": "octocat"}}, "comment": {"body": "/hint"}, "sender": {"login": "octocat"}}`, "1/octo/training/octocat"},
+		{"issue_comment", `{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "issue": {"user": {"login": "octocat"}}, "comment": {"body": "/advance"}, "sender": {"login": "mona"}}`, "1/octo/training/octocat"},
+		{"issue_comment", `{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "issue": {"user": {"login": "mona"}}, "comment": {"body": "/pause @octocat"}, "sender": {"login": "mona"}}`, "1/octo/training/octocat"},
+		{"issue_comment", `{"installation": {"id": 1}, "repository": {"full_name": "octo/training"}, "issue": {"user": {"login": "mona"}}, "comment": {"body": "/goto @octocat step-3"}, "sender": {"login": "mona"}}`, "1/octo/training/octocat"},
+	} {
+		if got := TraineeKey(tt.eventType, []byte(tt.payload)); got != tt.want {
+			t.Errorf("TraineeKey(%s, %s) = %s, want %s", tt.eventType, tt.payload, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// Recording is a webhook delivery as it was received.
+type Recording struct {
+	DeliveryID string            `json:"delivery_id"`
+	Event      string            `json:"event"`
+	ReceivedAt time.Time         `json:"received_at"`
+	Headers    map[string]string `json:"headers"`
+	Payload    json.RawMessage   `json:"payload"`
+	// Files holds the contents of files the payload only names, by commit
+	// and path, such as those a push added or modified.
+	Files map[string]map[string]string `json:"files,omitempty"`
+}
+
+// Enricher adds to a recording what its payload doesn't carry, before it is
+// written. It runs after the delivery's signature has been checked.
+type Enricher func(ctx context.Context, rec *Recording) error
+
+// redacted are the headers that are never written to a recording.
+var redacted = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"X-Hub-Signature":     true,
+	"X-Hub-Signature-256": true,
+}
+
+// Recorder writes webhook deliveries to files in a directory, for the
+// replay command. Only deliveries signed with the webhook secret are
+// recorded, with their signatures and credentials redacted. The enrichers
+// run after the delivery has been passed on, so they don't hold up its
+// acknowledgement; one that fails is logged and the delivery is recorded
+// without what it would have added.
+type Recorder struct {
+	dir       string
+	secret    []byte
+	enrichers []Enricher
+	pending   sync.WaitGroup
+}
+
+// NewRecorder returns a recorder that writes to dir.
+func NewRecorder(dir, secret string, enrichers ...Enricher) *Recorder {
+	return &Recorder{dir: dir, secret: []byte(secret), enrichers: enrichers}
+}
+
+// Wrap returns middleware that records each delivery before passing it on.
+func (rr *Recorder) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-GitHub-Event") != "" {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			rr.record(r, body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wait waits for the recordings still being enriched to be written.
+func (rr *Recorder) Wait() {
+	rr.pending.Wait()
+}
+
+var unsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// record starts writing the delivery, unless it isn't signed with the
+// secret, in which case the handler behind will reject it anyway.
+func (rr *Recorder) record(r *http.Request, body []byte) {
+	payload, err := github.ValidatePayload(r, rr.secret)
+	if err != nil {
+		logrus.WithError(err).Warn("Not recording a webhook that failed validation")
+		return
+	}
+	rec := Recording{
+		DeliveryID: r.Header.Get("X-GitHub-Delivery"),
+		Event:      r.Header.Get("X-GitHub-Event"),
+		ReceivedAt: time.Now().UTC(),
+		Headers:    map[string]string{},
+		Payload:    payload,
+	}
+	for name := range r.Header {
+		if !redacted[name] {
+			rec.Headers[name] = r.Header.Get(name)
+		}
+	}
+	if !json.Valid(rec.Payload) {
+		logrus.Errorf("Not recording delivery %s, which has an invalid payload", rec.DeliveryID)
+		return
+	}
+
+	rr.pending.Add(1)
+	go func() {
+		defer rr.pending.Done()
+		for _, enrich := range rr.enrichers {
+			if err := enrich(context.Background(), &rec); err != nil {
+				logrus.WithError(err).Warnf("Failed to enrich the recording of delivery %s", rec.DeliveryID)
+			}
+		}
+		if err := rr.write(rec); err != nil {
+			logrus.WithError(err).Error("Failed to record webhook")
+		}
+	}()
+}
+
+func (rr *Recorder) write(rec Recording) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(rr.dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create recording directory")
+	}
+	name := rec.ReceivedAt.Format("20060102T150405.000000000") + "-" + unsafe.ReplaceAllString(rec.Event+"-"+rec.DeliveryID, "_") + ".json"
+	return errors.Wrapf(ioutil.WriteFile(filepath.Join(rr.dir, name), data, 0600), "failed to record delivery %s", rec.DeliveryID)
+}
+
+// LoadRecordings reads recordings from the given files, and from the JSON
+// files in the given directories, and returns them in the order they were
+// received.
+func LoadRecordings(paths ...string) ([]Recording, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	var recordings []Recording
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode recording %s", file)
+		}
+		recordings = append(recordings, rec)
+	}
+	sort.SliceStable(recordings, func(i, j int) bool {
+		return recordings[i].ReceivedAt.Before(recordings[j].ReceivedAt)
+	})
+	return recordings, nil
+}